@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"sync"
+
+	"jeremy.visser.name/go/ssh-srv/pkg/srvdial"
+)
+
+// runBanner implements "ssh-srv banner". Every SRV target is connected to
+// at once, and the banner each one sends is printed, in SRV order, for an
+// inventory of what the fleet is running. Nothing is handed off.
+func runBanner(host string) error {
+	sd, err := newSRVDialer()
+	if err != nil {
+		return err
+	}
+	if sd.Peek == nil {
+		return errors.New("banner needs a banner to peek at: drop -no-peek, or give -expect for services other than ssh")
+	}
+	sd.State = nil // not a real connection, so nothing to learn from
+
+	timeout, err := connectDeadline()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeoutCause(context.Background(), timeout, srvdial.ErrTimeout)
+	defer cancel()
+	ctx, stop := cancelOnSignal(ctx)
+	defer stop()
+
+	cname, addrs, err := sd.LookupSRV(ctx, *service, *proto, host)
+	switch {
+	case err == nil:
+		log.Printf("%d SRV records found for %s", len(addrs), cname)
+	case srvdial.IsNotFound(err):
+		log.Printf("No SRV records, trying %s:22", host)
+		addrs = []*net.SRV{{Target: host, Port: 22}}
+	default:
+		return cancelled(ctx, err)
+	}
+	addrs, problems := srvdial.CheckSRV(addrs)
+	for _, problem := range problems {
+		log.Printf("%s: %s", cname, problem)
+	}
+	addrs = sd.Policy.Apply(addrs)
+
+	lines := make([]string, len(addrs))
+	ok := make([]bool, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sc, err := sd.DialTarget(ctx, *proto, addr)
+			if err != nil {
+				lines[i] = fmt.Sprintf("%s\t(%s)", srvdial.TargetKey(addr), err)
+				return
+			}
+			lines[i] = fmt.Sprintf("%s\t%s", srvdial.TargetKey(addr), srvdial.ServerBanner(sc.Conn))
+			ok[i] = true
+			sc.Close()
+		}()
+	}
+	wg.Wait()
+
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	if !slices.Contains(ok, true) {
+		return cancelled(ctx, srvdial.ErrAllTargetsFailed)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"jeremy.visser.name/go/ssh-srv/pkg/srvdial"
+)
+
+// runBrowse implements "ssh-srv browse". The DNS-SD instances of the
+// service under domain are listed, one line per SRV target, with their TXT
+// records, for seeing a whole fleet rather than one name. Nothing is
+// connected to.
+func runBrowse(domain string) error {
+	sd, err := newSRVDialer()
+	if err != nil {
+		return err
+	}
+
+	timeout, err := connectDeadline()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeoutCause(context.Background(), timeout, srvdial.ErrTimeout)
+	defer cancel()
+	ctx, stop := cancelOnSignal(ctx)
+	defer stop()
+
+	instances, err := sd.Browse(ctx, *service, *proto, domain)
+	if srvdial.IsNotFound(err) {
+		return fmt.Errorf("no _%s._%s instances advertised under %s", *service, *proto, domain)
+	} else if err != nil {
+		return cancelled(ctx, err)
+	}
+	log.Printf("%d instances found under %s", len(instances), domain)
+
+	for _, inst := range instances {
+		if inst.Err != nil {
+			fmt.Printf("%s\t(%s)\n", inst.Instance, inst.Err)
+			continue
+		}
+		var txt []string
+		for _, s := range inst.TXT {
+			if s != "" {
+				txt = append(txt, s)
+			}
+		}
+		for _, target := range inst.Targets {
+			line := fmt.Sprintf("%s\t%s\tprio %d, weight %d", inst.Instance, srvdial.TargetKey(target), target.Priority, target.Weight)
+			if len(txt) > 0 {
+				line += "\t" + strings.Join(txt, " ")
+			}
+			fmt.Println(line)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// compatArgs maps legacy invocation patterns onto the positional
+// HOSTNAME [PORT] arguments, so ssh_config files written for other proxy
+// commands keep working:
+//
+//	ssh-srv -W %h:%p         (ssh -W style)
+//	ssh-srv %h:%p            (a single combined argument)
+//	ssh-srv [%h]:%p          (the same, for IPv6 addresses)
+//	ssh-srv %h %p %r ...     (templates passing extra tokens)
+func compatArgs(w string, args []string) ([]string, error) {
+	if w != "" {
+		host, port, err := net.SplitHostPort(w)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -W %q: %w", w, err)
+		}
+		args = append([]string{host, port}, args...)
+	}
+
+	host, port, err := splitHostArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case port == "":
+		args = append([]string{host}, args[1:]...)
+	case len(args) == 1:
+		args = []string{host, port}
+	case args[1] == port:
+		args = append([]string{host}, args[1:]...)
+	default:
+		return nil, fmt.Errorf("port given twice: %q and %q", args[0], args[1])
+	}
+
+	if len(args) > 2 {
+		log.Printf("Ignoring extra arguments: %q", args[2:])
+		args = args[:2]
+	}
+	return args, nil
+}
+
+// splitHostArg splits a combined HOST:PORT or [ADDR]:PORT argument. Anything
+// else, bare IPv6 addresses included, is returned as the host with an empty
+// port.
+func splitHostArg(s string) (host, port string, err error) {
+	s = strings.TrimSpace(s)
+	if _, err := netip.ParseAddr(s); err == nil {
+		return s, "", nil
+	}
+
+	bracketed := strings.HasPrefix(s, "[")
+	switch {
+	case bracketed && strings.HasSuffix(s, "]"):
+		host = s[1 : len(s)-1]
+	case !bracketed && !strings.Contains(s, ":"):
+		return s, "", nil
+	case !bracketed && strings.Count(s, ":") > 1:
+		return "", "", fmt.Errorf("invalid host:port %q: IPv6 addresses with a port need brackets, as in [::1]:22", s)
+	default:
+		if host, port, err = net.SplitHostPort(s); err != nil {
+			return "", "", fmt.Errorf("invalid host:port %q: %w", s, err)
+		}
+		if port == "" {
+			return "", "", fmt.Errorf("invalid host:port %q: missing port", s)
+		}
+	}
+
+	if bracketed {
+		if addr, err := netip.ParseAddr(host); err != nil || !addr.Is6() {
+			return "", "", fmt.Errorf("invalid host %q: brackets are only for IPv6 addresses", s)
+		}
+	}
+	return host, port, nil
+}
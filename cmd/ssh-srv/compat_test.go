@@ -0,0 +1,74 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitHostArg(t *testing.T) {
+	tests := []struct {
+		in, host, port string
+		ok             bool
+	}{
+		{"host.example.com", "host.example.com", "", true},
+		{" host.example.com ", "host.example.com", "", true},
+		{"host.example.com:2222", "host.example.com", "2222", true},
+		{"192.0.2.1", "192.0.2.1", "", true},
+		{"192.0.2.1:22", "192.0.2.1", "22", true},
+		{"2001:db8::1", "2001:db8::1", "", true},
+		{"fe80::1%eth0", "fe80::1%eth0", "", true},
+		{"[2001:db8::1]", "2001:db8::1", "", true},
+		{"[2001:db8::1]:22", "2001:db8::1", "22", true},
+		{"[fe80::1%eth0]:22", "fe80::1%eth0", "22", true},
+
+		{"host.example.com:", "", "", false},
+		{"2001:db8::1:22:x", "", "", false},
+		{"host:22:23", "", "", false},
+		{"[host.example.com]:22", "", "", false},
+		{"[192.0.2.1]:22", "", "", false},
+		{"[2001:db8::1]:", "", "", false},
+		{"[2001:db8::1", "", "", false},
+	}
+	for _, tt := range tests {
+		host, port, err := splitHostArg(tt.in)
+		if ok := err == nil; ok != tt.ok || host != tt.host || port != tt.port {
+			t.Errorf("splitHostArg(%q) = %q, %q, %v; want %q, %q, ok %v", tt.in, host, port, err, tt.host, tt.port, tt.ok)
+		}
+	}
+}
+
+func TestCompatArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		w    string
+		args []string
+		want []string // nil for an error
+	}{
+		{"host", "", []string{"host"}, []string{"host"}},
+		{"host and port", "", []string{"host", "22"}, []string{"host", "22"}},
+		{"combined", "", []string{"host:2222"}, []string{"host", "2222"}},
+		{"bracketed", "", []string{"[2001:db8::1]:22"}, []string{"2001:db8::1", "22"}},
+		{"bare IPv6", "", []string{"2001:db8::1", "22"}, []string{"2001:db8::1", "22"}},
+		{"combined and the same port", "", []string{"host:22", "22"}, []string{"host", "22"}},
+		{"combined and another port", "", []string{"host:22", "2222"}, nil},
+		{"extra tokens", "", []string{"host", "22", "user", "extra"}, []string{"host", "22"}},
+		{"-W", "host:2222", nil, []string{"host", "2222"}},
+		{"-W IPv6", "[2001:db8::1]:22", nil, []string{"2001:db8::1", "22"}},
+		{"-W and extra tokens", "host:22", []string{"user"}, []string{"host", "22"}},
+		{"-W without a port", "host", nil, nil},
+		{"invalid combined", "", []string{"host:"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := compatArgs(tt.w, tt.args)
+			switch {
+			case tt.want == nil && err == nil:
+				t.Errorf("compatArgs(%q, %q) = %q, want an error", tt.w, tt.args, got)
+			case tt.want != nil && err != nil:
+				t.Errorf("compatArgs(%q, %q): %s", tt.w, tt.args, err)
+			case !slices.Equal(got, tt.want):
+				t.Errorf("compatArgs(%q, %q) = %q, want %q", tt.w, tt.args, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"jeremy.visser.name/go/ssh-srv/internal/fdpass"
+	"jeremy.visser.name/go/ssh-srv/internal/peek"
+	"jeremy.visser.name/go/ssh-srv/pkg/srvdial"
+)
+
+// doctor collects the findings of "ssh-srv doctor", printing each as it
+// goes.
+type doctor struct {
+	problems int
+}
+
+func (d *doctor) ok(format string, args ...any) {
+	fmt.Printf("ok    "+format+"\n", args...)
+}
+
+func (d *doctor) warn(format string, args ...any) {
+	fmt.Printf("warn  "+format+"\n", args...)
+}
+
+func (d *doctor) fail(format string, args ...any) {
+	fmt.Printf("FAIL  "+format+"\n", args...)
+	d.problems++
+}
+
+// runDoctor implements "ssh-srv doctor", checking what ssh-srv relies on:
+// the resolver, host's SRV records (if host isn't ""), the ssh client and
+// its config for host, and the kernel's support for passing and peeking at
+// sockets. Each finding is printed with what to do about it, and an error
+// is returned if anything is broken.
+func runDoctor(host string) error {
+	var d doctor
+	if err := socketOptions().Check(); err != nil {
+		d.fail("socket options: %s", err)
+	}
+	sd, err := newSRVDialer()
+	if err == nil {
+		var timeout time.Duration
+		if timeout, err = connectDeadline(); err == nil {
+			d.checkDNS(sd, host, timeout)
+		}
+	}
+	if err != nil {
+		d.fail("%s", err)
+	}
+	d.checkOpenSSH()
+	if host != "" {
+		d.checkSSHConfig(host)
+	} else {
+		d.warn("ssh_config: not checked, give HOSTNAME to check how ssh would connect to it")
+	}
+	d.checkFDPass()
+	d.checkPeek()
+
+	if d.problems > 0 {
+		if d.problems == 1 {
+			return errors.New("doctor: 1 problem found")
+		}
+		return fmt.Errorf("doctor: %d problems found", d.problems)
+	}
+	return nil
+}
+
+// checkDNS checks that the resolver answers, and that host's SRV targets
+// look right and resolve. Without a host, the resolver is asked about
+// "invalid.", which is never found (RFC 6761), but answering at all is
+// what counts. It all has to be done within timeout.
+func (d *doctor) checkDNS(sd *srvdial.SRVDialer, host string, timeout time.Duration) {
+	ctx, cancel := context.WithTimeoutCause(context.Background(), timeout, srvdial.ErrTimeout)
+	defer cancel()
+	r := sd.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+
+	name := cmp.Or(host, "invalid.")
+	start := time.Now()
+	cname, addrs, err := r.LookupSRV(ctx, *service, *proto, name)
+	took := time.Since(start).Round(10 * time.Microsecond)
+	switch {
+	case err == nil, srvdial.IsNotFound(err):
+		d.ok("resolver: answered in %s", took)
+	default:
+		d.fail("resolver: %s (check the nameservers in /etc/resolv.conf, or see -dns-timeout)", err)
+		return
+	}
+	if host == "" {
+		return
+	}
+
+	switch {
+	case srvdial.IsNotFound(err) && *noFallback:
+		d.fail("SRV: no records for _%s._%s.%s, and -no-fallback is given", *service, *proto, host)
+		return
+	case srvdial.IsNotFound(err):
+		d.warn("SRV: no records for _%s._%s.%s, so ssh-srv will connect to %s directly", *service, *proto, host, host)
+		return
+	}
+	d.ok("SRV: %d records for %s", len(addrs), cname)
+	addrs, problems := srvdial.CheckSRV(addrs)
+	for _, problem := range problems {
+		d.warn("SRV: %s", problem)
+	}
+	addrs = sd.Policy.Apply(addrs)
+	if len(addrs) == 0 {
+		d.fail("SRV: no targets left to connect to (see -policy and -exclude)")
+	}
+	for _, addr := range addrs {
+		if srvdial.IsOnion(addr.Target) {
+			continue
+		}
+		ips, err := r.LookupIPAddr(ctx, addr.Target)
+		if err != nil {
+			d.fail("SRV target %s: %s", srvdial.TargetKey(addr), err)
+			continue
+		}
+		d.ok("SRV target %s: %d addresses", srvdial.TargetKey(addr), len(ips))
+	}
+}
+
+// minFdPassVersion is the first OpenSSH with ProxyUseFdPass.
+var minFdPassVersion = [2]int{6, 5}
+
+var openSSHVersion = regexp.MustCompile(`OpenSSH_(\d+)\.(\d+)`)
+
+// checkOpenSSH checks that the ssh client is new enough for ProxyUseFdPass.
+func (d *doctor) checkOpenSSH() {
+	out, err := exec.Command("ssh", "-V").CombinedOutput()
+	if err != nil {
+		d.warn("ssh: %s, so can't tell whether it supports ProxyUseFdPass", cmp.Or(errors.Unwrap(err), err))
+		return
+	}
+	version := strings.TrimSpace(string(out))
+	m := openSSHVersion.FindStringSubmatch(version)
+	if m == nil {
+		d.warn("ssh: %q isn't OpenSSH, which is what ProxyUseFdPass needs (ssh-srv relays otherwise)", version)
+		return
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	if major < minFdPassVersion[0] || major == minFdPassVersion[0] && minor < minFdPassVersion[1] {
+		d.warn("ssh: %s predates ProxyUseFdPass (OpenSSH %d.%d), so ssh-srv will relay instead",
+			m[0], minFdPassVersion[0], minFdPassVersion[1])
+		return
+	}
+	d.ok("ssh: %s supports ProxyUseFdPass", m[0])
+}
+
+// checkSSHConfig checks, with ssh -G, that ssh would run ssh-srv for host,
+// and that fd 1 would be a socket to hand the connection over on.
+func (d *doctor) checkSSHConfig(host string) {
+	out, err := exec.Command("ssh", "-G", strings.TrimSuffix(host, ".")).Output()
+	if err != nil {
+		d.warn("ssh_config: ssh -G: %s", err)
+		return
+	}
+	config := map[string]string{}
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		if k, v, ok := strings.Cut(s.Text(), " "); ok {
+			config[k] = v
+		}
+	}
+
+	proxyCommand := config["proxycommand"]
+	exe, _ := os.Executable()
+	switch {
+	case proxyCommand == "" || proxyCommand == "none":
+		d.fail("ssh_config: no ProxyCommand for %s, so ssh won't use ssh-srv (see %s install)", host, os.Args[0])
+		return
+	case !strings.Contains(proxyCommand, "ssh-srv") && !strings.Contains(proxyCommand, filepath.Base(exe)):
+		d.warn("ssh_config: the ProxyCommand for %s doesn't look like ssh-srv: %s", host, proxyCommand)
+	default:
+		d.ok("ssh_config: ProxyCommand for %s: %s", host, proxyCommand)
+	}
+	if config["proxyusefdpass"] != "yes" {
+		d.warn("ssh_config: ProxyUseFdPass is off for %s, so fd 1 will be a pipe, and ssh-srv will relay rather than hand over the socket", host)
+		return
+	}
+	d.ok("ssh_config: ProxyUseFdPass is on, so fd 1 will be a socket to hand over on")
+}
+
+// checkFDPass passes a socket over a socketpair, as ssh-srv does to ssh,
+// to see that the kernel supports SCM_RIGHTS.
+func (d *doctor) checkFDPass() {
+	err := func() error {
+		fds, err := fdpass.Socketpair()
+		if err != nil {
+			return err
+		}
+		defer fdpass.Close(fds[0])
+		defer fdpass.Close(fds[1])
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		if err := srvdial.Handoff(c, fds[0], handoffTimeout); err != nil {
+			return err
+		}
+
+		fd, err := fdpass.Receive(fds[1])
+		if err != nil {
+			return err
+		}
+		fdpass.Close(fd)
+		return nil
+	}()
+	if err != nil {
+		d.fail("fd passing: %s (-relay works without it)", err)
+		return
+	}
+	d.ok("fd passing: SCM_RIGHTS works")
+}
+
+// checkPeek reads a banner with MSG_PEEK, as peeking at targets does, and
+// checks that it's still there to read afterwards.
+func (d *doctor) checkPeek() {
+	const banner = "SSH-2.0-doctor\r\n"
+	err := func() error {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+		go func() {
+			if c, err := ln.Accept(); err == nil {
+				c.Write([]byte(banner))
+				c.Close()
+			}
+		}()
+		c, err := net.DialTimeout("tcp", ln.Addr().String(), time.Second)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, len(banner))
+		for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+			var n int
+			err := srvdial.ConnFD(c, func(fd int) (err error) {
+				n, err = peek.Try(fd, buf)
+				return err
+			})
+			switch {
+			case err != nil && !errors.Is(err, peek.ErrWouldBlock):
+				return fmt.Errorf("MSG_PEEK: %w", err)
+			case n == len(banner):
+			case time.Now().After(deadline):
+				return fmt.Errorf("MSG_PEEK: only %d of %d bytes", n, len(banner))
+			default:
+				continue
+			}
+			break
+		}
+		if _, err := c.Read(buf); err != nil {
+			return err
+		}
+		if string(buf) != banner {
+			return fmt.Errorf("read %q after peeking, not %q", buf, banner)
+		}
+		return nil
+	}()
+	if err != nil {
+		d.fail("peeking: %s (see -no-peek)", err)
+		return
+	}
+	d.ok("peeking: MSG_PEEK works")
+}
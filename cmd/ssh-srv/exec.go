@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// execWith runs command through the shell with conn as its stdin and
+// stdout, and waits for it to exit.
+func execWith(command string, conn net.Conn) error {
+	f, err := dupFile(conn)
+	if err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+	defer f.Close()
+	conn.Close() // the child owns the connection now
+
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Stdin = f
+	cmd.Stdout = f
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// cmdPeek checks connections by running Command through the shell with a
+// dup of the socket as its stdin, accepting the connection if it exits 0.
+// The socket is still handed over afterwards, so the command should only
+// peek at it (MSG_PEEK), not read from it.
+type cmdPeek struct {
+	Command string
+	Timeout time.Duration // if non-zero, kill the command after this long
+}
+
+func (cp *cmdPeek) Peek(conn net.Conn) error {
+	f, err := dupFile(conn)
+	if err != nil {
+		return fmt.Errorf("peek-cmd: %v", err)
+	}
+	defer f.Close()
+	defer restoreNonblock(conn) // for whatever peeks or reads next
+
+	ctx := context.Background()
+	if cp.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cp.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", cp.Command)
+	cmd.Stdin = f
+	cmd.Stdout = os.Stderr // fd 1 may be ssh's socket
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "SSH_SRV_PEER="+conn.RemoteAddr().String())
+	if err := cmd.Run(); err != nil {
+		// not %w: main exits with the status of an *exec.ExitError, as
+		// -exec's command's
+		return fmt.Errorf("peek-cmd: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"testing"
+
+	"jeremy.visser.name/go/ssh-srv/pkg/srvdial"
+)
+
+func TestCmdPeek(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	tests := []struct {
+		command string
+		ok      bool
+	}{
+		{"exit 0", true},
+		{"exit 7", false},
+	}
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = (&cmdPeek{Command: tt.command}).Peek(conn)
+		conn.Close()
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("peek-cmd %q: %v, want ok %v", tt.command, err, tt.ok)
+			continue
+		}
+		if tt.ok {
+			continue
+		}
+
+		// as from DialSRVContext, which mustn't exit with the command's
+		// status as if it were -exec's
+		err = fmt.Errorf("%w: %w: %w", srvdial.ErrAllTargetsFailed, srvdial.ErrPeekMismatch, err)
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			t.Errorf("peek-cmd %q: %v unwraps to an *exec.ExitError", tt.command, err)
+		}
+		if got := exitCode(err); got != exitPeekMismatch {
+			t.Errorf("peek-cmd %q: exit code %d, want %d", tt.command, got, exitPeekMismatch)
+		}
+	}
+}
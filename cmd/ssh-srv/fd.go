@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net"
+	"os"
+
+	"jeremy.visser.name/go/ssh-srv/internal/fdpass"
+	"jeremy.visser.name/go/ssh-srv/pkg/srvdial"
+)
+
+// dupFile returns a dup of conn's file descriptor, for giving to a child
+// process, which the caller must close. The socket is put in blocking mode,
+// as children expect of their standard input and output; conn shares the
+// mode, so see restoreNonblock if it's still to be used.
+func dupFile(conn net.Conn) (*os.File, error) {
+	var f *os.File
+	err := srvdial.ConnFD(conn, func(fd int) error {
+		dup, err := fdpass.Dup(fd)
+		if err != nil {
+			return err
+		}
+		if err := fdpass.SetBlocking(dup, true); err != nil {
+			fdpass.Close(dup)
+			return err
+		}
+		f = os.NewFile(uintptr(dup), conn.RemoteAddr().String())
+		return nil
+	})
+	return f, err
+}
+
+// restoreNonblock puts conn's socket back in non-blocking mode, which the
+// runtime's poller relies on, after a child process was given a dup of it.
+func restoreNonblock(conn net.Conn) error {
+	return srvdial.ConnFD(conn, func(fd int) error {
+		return fdpass.SetBlocking(fd, false)
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+
+	"jeremy.visser.name/go/ssh-srv/pkg/srvdial"
+)
+
+// runGenConfig implements "ssh-srv genconfig", printing ssh_config Host
+// blocks for what DNS says about domain: one for domain itself if it has SRV
+// records, and one for each DNS-SD instance advertised under it, connecting
+// to that instance's target with -target. Any other flags given to ssh-srv
+// are passed on to the ProxyCommands.
+func runGenConfig(domain string, flags []string) error {
+	sd, err := newSRVDialer()
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	name := strings.TrimSuffix(domain, ".")
+
+	timeout, err := connectDeadline()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeoutCause(context.Background(), timeout, srvdial.ErrTimeout)
+	defer cancel()
+	ctx, stop := cancelOnSignal(ctx)
+	defer stop()
+
+	var srvs []*net.SRV
+	var instances []srvdial.Instance
+	errs := make(chan error, 2)
+	go func() {
+		var err error
+		_, srvs, err = sd.LookupSRV(ctx, *service, *proto, domain)
+		if err == nil {
+			srvs, _ = srvdial.CheckSRV(srvs)
+			srvs = sd.Policy.Apply(srvs)
+			srvs = slices.DeleteFunc(srvs, func(srv *net.SRV) bool { return !configSafe(srv, "SRV target") })
+		}
+		errs <- err
+	}()
+	go func() {
+		var err error
+		instances, err = sd.Browse(ctx, *service, *proto, domain)
+		errs <- err
+	}()
+	for range 2 {
+		if err := <-errs; err != nil && !srvdial.IsNotFound(err) {
+			return cancelled(ctx, err)
+		}
+	}
+	if len(srvs) == 0 && len(instances) == 0 {
+		return fmt.Errorf("no SRV records or DNS-SD instances for _%s._%s.%s", *service, *proto, name)
+	}
+	log.Printf("%d SRV targets and %d DNS-SD instances found for %s", len(srvs), len(instances), name)
+
+	fmt.Printf("# written by ssh-srv genconfig %s\n", name)
+	if len(srvs) > 0 {
+		slices.SortStableFunc(srvs, func(a, b *net.SRV) int {
+			return cmp.Or(cmp.Compare(a.Priority, b.Priority), cmp.Compare(b.Weight, a.Weight))
+		})
+		fmt.Printf("\nHost %s\n", name)
+		fmt.Printf("\tProxyUseFdPass yes\n")
+		fmt.Printf("\tProxyCommand %s\n", shellJoin(append(append([]string{exe}, flags...), "%h", "%p")))
+		if len(srvs) == 1 {
+			fmt.Printf("\tHostKeyAlias %s\n", hostKeyAlias(srvs[0]))
+		} else {
+			// Pinning one alias would make the others' keys mismatch, so
+			// they're left for -hostkeyalias to pick per connection:
+			fmt.Printf("\t# one HostKeyAlias per target, as -hostkeyalias would write:\n")
+			for _, srv := range srvs {
+				fmt.Printf("\t#HostKeyAlias %s\n", hostKeyAlias(srv))
+			}
+		}
+	}
+
+	for _, inst := range instances {
+		inst.Targets = slices.DeleteFunc(inst.Targets, func(srv *net.SRV) bool { return !configSafe(srv, "DNS-SD target") })
+		if strings.ContainsFunc(inst.Name, unicode.IsControl) {
+			inst.Err = cmp.Or(inst.Err, fmt.Errorf("control characters in %q", inst.Name))
+		}
+		if inst.Err != nil || len(inst.Targets) == 0 {
+			log.Printf("Skipping DNS-SD instance %q: %v", inst.Instance, cmp.Or(inst.Err, fmt.Errorf("no SRV targets")))
+			continue
+		}
+		slices.SortStableFunc(inst.Targets, func(a, b *net.SRV) int {
+			return cmp.Compare(a.Priority, b.Priority)
+		})
+		target := inst.Targets[0]
+		fmt.Printf("\n# DNS-SD instance %s\n", strings.TrimSuffix(inst.Name, "."))
+		fmt.Printf("Host %s\n", hostPattern(inst.Instance))
+		fmt.Printf("\tProxyUseFdPass yes\n")
+		fmt.Printf("\tProxyCommand %s\n", shellJoin(append(append([]string{exe}, flags...),
+			"-target", srvdial.TargetKey(target), "%h", "%p")))
+		fmt.Printf("\tHostKeyAlias %s\n", hostKeyAlias(target))
+		for _, txt := range inst.TXT {
+			// as in Avahi's ssh service files:
+			user, ok := strings.CutPrefix(txt, "u=")
+			switch {
+			case !ok || user == "":
+			case !validUser(user):
+				log.Printf("Ignoring DNS-SD instance %q's user %q: not a valid username", inst.Instance, user)
+			default:
+				fmt.Printf("\tUser %s\n", user)
+			}
+		}
+	}
+	return nil
+}
+
+// hostPattern turns a DNS-SD instance name, which can be any text, into
+// something usable as a Host in ssh_config, lowercased with anything but
+// letters, digits, dots and dashes as dashes.
+func hostPattern(instance string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', '0' <= r && r <= '9', r == '.', r == '-':
+			return r
+		case 'A' <= r && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, instance)
+}
+
+// configSafe reports whether srv's target is a hostname that can be written
+// into ssh_config as it is, logging it as a kind to skip if not: it comes
+// from DNS, where a name can hold whitespace, newlines and all.
+func configSafe(srv *net.SRV, kind string) bool {
+	target := strings.TrimSuffix(srv.Target, ".")
+	if _, err := parseHost(target); err != nil || strings.ContainsFunc(target, unicode.IsSpace) {
+		log.Printf("Skipping %s %q: not a valid hostname", kind, srv.Target)
+		return false
+	}
+	return true
+}
+
+// validUser reports whether user is a portable username (POSIX's portable
+// filename characters, not starting with '-'), safe to write as a User.
+func validUser(user string) bool {
+	if user == "" || user[0] == '-' {
+		return false
+	}
+	return strings.Trim(user, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789._-") == ""
+}
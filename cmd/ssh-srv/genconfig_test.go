@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestValidUser(t *testing.T) {
+	tests := []struct {
+		user string
+		want bool
+	}{
+		{"jeremy", true},
+		{"svc_backup-2", true},
+		{"first.last", true},
+		{"", false},
+		{"-oProxyCommand=x", false},
+		{"root\n\tProxyCommand x", false},
+		{"two words", false},
+		{"user@example.com", false},
+		{"%d", false},
+	}
+	for _, tt := range tests {
+		if got := validUser(tt.user); got != tt.want {
+			t.Errorf("validUser(%q) = %v, want %v", tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestConfigSafe(t *testing.T) {
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"bastion.example.com.", true},
+		{"bastion.example.com", true},
+		{"bastion.example.com\n\tProxyCommand x.", false},
+		{"bastion example.com.", false},
+		{"bastion.example.com .", false},
+		{"bastion\x00.example.com.", false},
+	}
+	for _, tt := range tests {
+		if got := configSafe(&net.SRV{Target: tt.target, Port: 22}, "target"); got != tt.want {
+			t.Errorf("configSafe(%q) = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestHostPattern(t *testing.T) {
+	if got, want := hostPattern("Jeremy's Mac\n*"), "jeremy-s-mac--"; got != want {
+		t.Errorf("hostPattern = %q, want %q", got, want)
+	}
+}
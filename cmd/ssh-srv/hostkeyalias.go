@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// recordTarget writes an ssh_config snippet to dir/HOST.conf that sets
+// HostKeyAlias to the SRV target connected to, so that each backend's host
+// key is pinned under its own name. ssh reads its config before running
+// the ProxyCommand, so the snippet takes effect from the next connection,
+// which is why -hostkeyalias needs -target or -sticky, for that to be to
+// the same target. The alias the snippet had before, if any, is returned,
+// being what ssh checks this connection's key under. A nil target (the
+// non-SRV fallback) removes the snippet.
+func recordTarget(dir, host string, target *net.SRV) (previous string, err error) {
+	name := filepath.Join(dir, strings.TrimSuffix(host, ".")+".conf")
+	if old, err := os.ReadFile(name); err == nil {
+		for _, line := range strings.Split(string(old), "\n") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(line), " "); ok && k == "HostKeyAlias" {
+				previous = v
+			}
+		}
+	}
+	if target == nil {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return previous, err
+		}
+		return previous, nil
+	}
+
+	snippet := fmt.Sprintf("# written by ssh-srv\nHost %s\n\tHostKeyAlias %s\n",
+		strings.TrimSuffix(host, "."), hostKeyAlias(target))
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return previous, err
+	}
+	f, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return previous, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(snippet); err != nil {
+		f.Close()
+		return previous, err
+	}
+	if err := f.Close(); err != nil {
+		return previous, err
+	}
+	return previous, os.Rename(f.Name(), name)
+}
+
+// hostKeyAlias names target the way known_hosts would: bracketed with its
+// port, unless that's 22.
+func hostKeyAlias(target *net.SRV) string {
+	alias := strings.TrimSuffix(target.Target, ".")
+	if target.Port != 22 {
+		alias = fmt.Sprintf("[%s]:%d", alias, target.Port)
+	}
+	return alias
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"jeremy.visser.name/go/ssh-srv/pkg/srvdial"
+)
+
+// parseHost validates a HOSTNAME argument and returns it normalized: with
+// surrounding whitespace removed and lowercased. A single trailing dot (a
+// fully-qualified name) is kept. IP address literals are accepted as-is.
+func parseHost(s string) (string, error) {
+	host := strings.TrimSpace(s)
+	if host == "" {
+		return "", fmt.Errorf("empty hostname")
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return host, nil
+	}
+
+	if len(strings.TrimSuffix(host, ".")) > 253 {
+		return "", fmt.Errorf("hostname %q is longer than 253 characters", host)
+	}
+	for _, r := range host {
+		switch {
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			return "", fmt.Errorf("hostname %q contains whitespace", host)
+		case r > 0x7f:
+			return "", fmt.Errorf("hostname %q is not ASCII (use the xn-- form for IDNs)", host)
+		case !isHostChar(byte(r)):
+			return "", fmt.Errorf("hostname %q contains invalid character %q", host, r)
+		}
+	}
+
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		switch {
+		case label == "":
+			return "", fmt.Errorf("hostname %q has an empty label", host)
+		case len(label) > 63:
+			return "", fmt.Errorf("hostname %q has a label longer than 63 characters", host)
+		case label[0] == '-' || label[len(label)-1] == '-':
+			return "", fmt.Errorf("hostname %q has a label starting or ending with '-'", host)
+		}
+	}
+
+	return strings.ToLower(host), nil
+}
+
+// isHostChar reports whether c may appear in a hostname. Underscores
+// aren't valid in hostnames, but are common enough in private zones.
+func isHostChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '_' || c == '.'
+}
+
+// parsePort validates a PORT argument, which must be a number from 1 to
+// 65535, and returns it without surrounding whitespace.
+func parsePort(s string) (string, error) {
+	port := strings.TrimSpace(s)
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || n == 0 {
+		return "", fmt.Errorf("invalid port %q: must be a number from 1 to 65535", s)
+	}
+	return port, nil
+}
+
+// parseTarget validates a -target argument, HOST:PORT as printed by list,
+// and returns it as the SRV target it stands for.
+func parseTarget(s string) (*net.SRV, error) {
+	h, p, err := net.SplitHostPort(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	host, err := parseHost(h)
+	if err != nil {
+		return nil, err
+	}
+	port, err := parsePort(p)
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(port)
+	return &net.SRV{Target: host, Port: uint16(n)}, nil
+}
+
+// parseResolve parses a -resolve argument, HOST:PORT:ADDRESS[,ADDRESS...]
+// as for curl --resolve, with IPv6 addresses optionally in brackets, into
+// the TargetKey and addresses for SRVDialer.Resolve.
+func parseResolve(s string) (string, []net.IPAddr, error) {
+	host, rest, _ := strings.Cut(s, ":")
+	port, addrs, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", nil, fmt.Errorf("%q is not HOST:PORT:ADDRESS", s)
+	}
+	host, err := parseHost(host)
+	if err != nil {
+		return "", nil, err
+	}
+	if port, err = parsePort(port); err != nil {
+		return "", nil, err
+	}
+	var ips []net.IPAddr
+	for _, a := range strings.Split(addrs, ",") {
+		ip, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(a), "["), "]"))
+		if err != nil {
+			return "", nil, err
+		}
+		ips = append(ips, net.IPAddr{IP: ip.AsSlice(), Zone: ip.Zone()})
+	}
+	n, _ := strconv.Atoi(port)
+	return srvdial.TargetKey(&net.SRV{Target: host, Port: uint16(n)}), ips, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseHost(t *testing.T) {
+	tests := []struct {
+		in, want string
+		wantErr  string // in the error, if it fails
+	}{
+		{in: "host.example.com", want: "host.example.com"},
+		{in: "  Host.Example.COM\t", want: "host.example.com"},
+		{in: "host.example.com.", want: "host.example.com."},
+		{in: "_private.example.com", want: "_private.example.com"},
+		{in: "localhost", want: "localhost"},
+		{in: "192.0.2.1", want: "192.0.2.1"},
+		{in: "2001:DB8::1", want: "2001:DB8::1"},
+		{in: "fe80::1%eth0", want: "fe80::1%eth0"},
+		{in: strings.Repeat("a.", 126) + "b.", want: strings.Repeat("a.", 126) + "b."},
+
+		{in: "", wantErr: "empty hostname"},
+		{in: "   ", wantErr: "empty hostname"},
+		{in: strings.Repeat("a.", 126) + "bc", wantErr: "longer than 253"},
+		{in: "host example.com", wantErr: "whitespace"},
+		{in: "host\nexample.com", wantErr: "whitespace"},
+		{in: "bücher.example", wantErr: "not ASCII"},
+		{in: "host;rm -rf.example.com", wantErr: "invalid character"},
+		{in: "host/example.com", wantErr: "invalid character"},
+		{in: "host..example.com", wantErr: "empty label"},
+		{in: ".example.com", wantErr: "empty label"},
+		{in: "host.example.com..", wantErr: "empty label"},
+		{in: strings.Repeat("a", 64) + ".example.com", wantErr: "longer than 63"},
+		{in: "-host.example.com", wantErr: "starting or ending with '-'"},
+		{in: "host-.example.com", wantErr: "starting or ending with '-'"},
+	}
+	for _, tt := range tests {
+		got, err := parseHost(tt.in)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("parseHost(%q): %s", tt.in, err)
+		case tt.wantErr == "" && got != tt.want:
+			t.Errorf("parseHost(%q) = %q, want %q", tt.in, got, tt.want)
+		case tt.wantErr != "" && err == nil:
+			t.Errorf("parseHost(%q) = %q, want an error", tt.in, got)
+		case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+			t.Errorf("parseHost(%q): %q, want an error about %q", tt.in, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParsePort(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"22", "22", true},
+		{" 2222\n", "2222", true},
+		{"1", "1", true},
+		{"65535", "65535", true},
+		{"0", "", false},
+		{"65536", "", false},
+		{"-1", "", false},
+		{"+22", "", false},
+		{"ssh", "", false},
+		{"", "", false},
+		{"22 23", "", false},
+	}
+	for _, tt := range tests {
+		got, err := parsePort(tt.in)
+		if ok := err == nil; ok != tt.ok || got != tt.want {
+			t.Errorf("parsePort(%q) = %q, %v; want %q, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runInstall implements "ssh-srv install", adding a Host block for the
+// given patterns to the user's ssh_config that goes through ssh-srv, with
+// any other flags given to ssh-srv. The block is marked with comments, so
+// that running it again updates it in place rather than adding another.
+func runInstall(flags, args []string) error {
+	fls := flag.NewFlagSet("install", flag.ExitOnError)
+	dryRun := fls.Bool("n", false, "print what would be written instead of writing it")
+	config := fls.String("config", filepath.Join("~", ".ssh", "config"), "add the Host block to `file`")
+	fls.Usage = func() {
+		fmt.Fprintf(fls.Output(), "usage: %s [OPTIONS] install [-n] [-config FILE] PATTERN...\n", os.Args[0])
+		fls.PrintDefaults()
+	}
+	fls.Parse(args)
+	if fls.NArg() < 1 {
+		fls.Usage()
+		os.Exit(1)
+	}
+	for _, pattern := range fls.Args() {
+		for _, r := range pattern {
+			if r > 0x7f || !isHostChar(byte(r)) && !strings.ContainsRune("*?!", r) {
+				return fmt.Errorf("invalid Host pattern %q", pattern)
+			}
+		}
+	}
+	patterns := strings.Join(fls.Args(), " ")
+
+	name := *config
+	if rest, ok := strings.CutPrefix(name, "~"+string(filepath.Separator)); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		name = filepath.Join(home, rest)
+	}
+	// write through a symlink (such as into a dotfiles repo), not over it:
+	if target, err := filepath.EvalSymlinks(name); err == nil {
+		name = target
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	begin, end := "# BEGIN ssh-srv "+patterns, "# END ssh-srv "+patterns
+	block := strings.Join([]string{
+		begin,
+		"Host " + patterns,
+		"\tProxyUseFdPass yes",
+		"\tProxyCommand " + shellJoin(append(append([]string{exe}, flags...), "%h", "%p")),
+		end,
+	}, "\n") + "\n"
+
+	old, err := os.ReadFile(name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	updated, replaced, err := replaceBlock(string(old), begin, end, block)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	switch {
+	case updated == string(old):
+		log.Printf("%s already has the Host block for %s", name, patterns)
+		return nil
+	case *dryRun && replaced:
+		log.Printf("Would update the Host block for %s in %s:", patterns, name)
+		fmt.Print(block)
+		return nil
+	case *dryRun:
+		log.Printf("Would append to %s:", name)
+		fmt.Print(block)
+		return nil
+	}
+
+	if err := writeFileAtomic(name, []byte(updated)); err != nil {
+		return err
+	}
+	if replaced {
+		log.Printf("Updated the Host block for %s in %s", patterns, name)
+	} else {
+		log.Printf("Appended a Host block for %s to %s", patterns, name)
+	}
+	return nil
+}
+
+// replaceBlock returns config with the lines from begin to end replaced by
+// block, or if there are none, with block appended after a blank line.
+func replaceBlock(config, begin, end, block string) (_ string, replaced bool, _ error) {
+	lines := strings.SplitAfter(config, "\n")
+	start := -1
+	for i, line := range lines {
+		switch strings.TrimSpace(line) {
+		case begin:
+			start = i
+		case end:
+			if start < 0 {
+				return "", false, fmt.Errorf("%q without %q before it", end, begin)
+			}
+			return strings.Join(lines[:start], "") + block + strings.Join(lines[i+1:], ""), true, nil
+		}
+	}
+	if start >= 0 {
+		return "", false, fmt.Errorf("%q without %q after it", begin, end)
+	}
+
+	if config != "" {
+		if !strings.HasSuffix(config, "\n") {
+			config += "\n"
+		}
+		config += "\n"
+	}
+	return config + block, false, nil
+}
+
+// writeFileAtomic replaces name with data by renaming a temporary file over
+// it, so that ssh never reads half of it. Its permissions are kept, or for
+// a new file, it's only readable by the user, as ssh insists on.
+func writeFileAtomic(name string, data []byte) error {
+	mode := fs.FileMode(0o600)
+	if fi, err := os.Stat(name); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
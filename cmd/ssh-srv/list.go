@@ -0,0 +1,66 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+
+	"jeremy.visser.name/go/ssh-srv/pkg/srvdial"
+)
+
+// runList implements "ssh-srv list". host's SRV targets are printed one to
+// a line, as target, port, priority, weight and connect latency (in
+// milliseconds, from -state, or "-" if unknown), separated by tabs, for
+// scripts and pickers like fzf to read. The order is stable: by priority,
+// then heaviest weight first, then by name. Nothing is connected to.
+func runList(host string) error {
+	sd, err := newSRVDialer()
+	if err != nil {
+		return err
+	}
+
+	timeout, err := connectDeadline()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeoutCause(context.Background(), timeout, srvdial.ErrTimeout)
+	defer cancel()
+	ctx, stop := cancelOnSignal(ctx)
+	defer stop()
+
+	cname, addrs, err := sd.LookupSRV(ctx, *service, *proto, host)
+	if srvdial.IsNotFound(err) {
+		return fmt.Errorf("no SRV records for _%s._%s.%s", *service, *proto, host)
+	} else if err != nil {
+		return cancelled(ctx, err)
+	}
+	log.Printf("%d SRV records found for %s", len(addrs), cname)
+	addrs, problems := srvdial.CheckSRV(addrs)
+	for _, problem := range problems {
+		log.Printf("%s: %s", cname, problem)
+	}
+	addrs = sd.Policy.Apply(addrs)
+	for _, problem := range sd.CheckResolvable(ctx, addrs) {
+		log.Printf("%s: %s", cname, problem)
+	}
+
+	slices.SortStableFunc(addrs, func(a, b *net.SRV) int {
+		return cmp.Or(
+			cmp.Compare(a.Priority, b.Priority),
+			cmp.Compare(b.Weight, a.Weight),
+			strings.Compare(srvdial.TargetKey(a), srvdial.TargetKey(b)),
+		)
+	})
+	for _, addr := range addrs {
+		latency := "-"
+		if d, ok := sd.State.Latency(addr); ok {
+			latency = fmt.Sprintf("%.1f", d.Seconds()*1000)
+		}
+		fmt.Printf("%s\t%d\t%d\t%d\t%s\n", strings.TrimSuffix(addr.Target, "."), addr.Port, addr.Priority, addr.Weight, latency)
+	}
+	return nil
+}
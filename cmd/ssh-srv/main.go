@@ -0,0 +1,931 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"jeremy.visser.name/go/ssh-srv/internal/fdpass"
+	"jeremy.visser.name/go/ssh-srv/pkg/srvdial"
+)
+
+const introText = `SUMMARY
+
+	Resolves an _ssh._tcp SRV record, and passes the socket to SSH via ProxyUseFdPass.
+
+USAGE
+
+		%[1]s [OPTIONS] HOSTNAME [PORT]
+		%[1]s -exec COMMAND HOSTNAME [PORT]
+		%[1]s [OPTIONS] preheat HOSTNAME
+		%[1]s mosh [-print] [USER@]HOSTNAME [MOSH-OPTIONS...]
+		%[1]s [OPTIONS] banner HOSTNAME
+		%[1]s [OPTIONS] list HOSTNAME
+		%[1]s [OPTIONS] browse DOMAIN
+		%[1]s [OPTIONS] genconfig DOMAIN
+		%[1]s [OPTIONS] install [-n] [-config FILE] PATTERN...
+		%[1]s [OPTIONS] doctor [HOSTNAME]
+		%[1]s [OPTIONS] wrap git|rsync
+		%[1]s [OPTIONS] test-env [-v] [SCENARIO...]
+
+	The socket is handed to fd 1 (or the fd given by -fd, or the Unix socket
+	given by -send-to) using ancilliary data.
+	If that fd is not a socket (ProxyUseFdPass=no), data is relayed over
+	stdin/stdout instead.
+
+	Port is optional, and only used in the case of non-SRV fallback.
+	If SRV records are found, the port from the SRV is used instead.
+	IP addresses are connected to directly, without looking for SRV records.
+	The two can also be given as one HOSTNAME:PORT argument, with IPv6
+	addresses in brackets ([2001:db8::1]:22).
+	A HOSTNAME named after a subcommand, such as list, is still a HOSTNAME
+	when a PORT follows it; without one, put -- before it (%[1]s -- list).
+
+	With -exec, COMMAND is run through the shell with the connected socket as
+	its stdin and stdout, for using SRV records with other tools.
+
+	preheat resolves HOSTNAME in the background and exits immediately, to
+	warm caching resolvers from a "Match exec" hook before the real
+	ProxyCommand runs. It looks up what OPTIONS such as -service and -mdns
+	say to, but doesn't connect.
+
+	mosh races the SRV targets as usual, then runs mosh against the winning
+	target and port, as mosh can't take a passed socket.
+
+	banner connects to every SRV target at once, prints the banner each one
+	sends, and exits, to see what versions a fleet of bastions is running.
+
+	list prints HOSTNAME's SRV targets, one to a line, as tab-separated
+	target, port, priority, weight and known connect latency in
+	milliseconds (or "-"), for scripts and pickers like fzf, which can then
+	connect to the one chosen with -target HOST:PORT.
+
+	browse lists the DNS-SD instances advertised under DOMAIN (the PTR
+	records at _ssh._tcp.DOMAIN), with their SRV targets and TXT records,
+	to see a whole fleet rather than one name. For "local", they're found
+	with multicast DNS.
+
+	genconfig prints ssh_config Host blocks for DOMAIN's SRV records and
+	DNS-SD instances, with ProxyCommand lines carrying the given OPTIONS
+	and suggested HostKeyAliases, ready to paste into ~/.ssh/config.
+
+	install adds a Host block for the PATTERNs using %[1]s, with the given
+	OPTIONS, to ~/.ssh/config, or updates the one it added before. With -n,
+	it's printed instead.
+
+	doctor checks the resolver, HOSTNAME's SRV records, the ssh client and
+	its config for HOSTNAME, and the kernel's support for passing and
+	peeking at sockets, printing what to do about anything amiss.
+
+	wrap prints a shell command setting GIT_SSH_COMMAND or RSYNC_RSH, so
+	that git or rsync connect through %[1]s, with the given OPTIONS.
+
+	test-env runs %[1]s, with the given OPTIONS, through canned scenarios
+	against a fake DNS server and sshd listeners in a throwaway network
+	namespace (Linux only), to reproduce target selection problems.
+
+EXAMPLES
+
+	ssh -o ProxyUseFdPass=yes -o ProxyCommand='%[1]s %%h %%p' user@hostname
+
+	# ~/.ssh/ssh_config
+	Host *.mydomain.invalid
+		ProxyUseFdPass  yes
+		ProxyCommand    %[1]s %%h %%p
+
+	eval "$(%[1]s wrap git)"; git clone user@hostname:repo.git
+
+	Match host *.mydomain.invalid exec "%[1]s preheat %%h"
+		ProxyUseFdPass  yes
+		ProxyCommand    %[1]s %%h %%p
+
+EXIT STATUS
+
+	0 when the socket was handed over, and 3 when a connection was made,
+	but handing it over failed. When none could be made, 4 if there were no
+	SRV records (with -no-fallback), 5 if every SRV target failed, 6 if one
+	of them sent the wrong banner (see -expect), 124 if it took too long,
+	and 1 otherwise. 130 when interrupted (SIGINT or SIGTERM) while
+	connecting. With -exec, the command's exit status.
+
+OPTIONS
+
+`
+
+const (
+	// peekTimeoutDefault is the default -peek-timeout.
+	peekTimeoutDefault = 5 * time.Second
+
+	// handoffTimeout bounds passing the socket on, should the receiver
+	// not be reading.
+	handoffTimeout = 10 * time.Second
+
+	// gaiConfDefault is the default -gai-conf, which needn't exist.
+	gaiConfDefault = "/etc/gai.conf"
+)
+
+// Exit codes, besides that of an -exec command.
+const (
+	exitFailure      = 1 // no connection, or bad usage
+	exitHandoff      = 3 // connected, but the socket couldn't be passed on
+	exitNoRecords    = 4 // no SRV records, and not falling back
+	exitUnreachable  = 5 // every SRV target failed
+	exitPeekMismatch = 6 // every SRV target failed, some of them the peek
+
+	exitTimeout   = 124 // out of time, as timeout(1) reports
+	exitCancelled = 130 // SIGINT or SIGTERM while connecting, as shells report SIGINT
+)
+
+func init() {
+	log.SetFlags(0)
+	log.SetPrefix(os.Args[0] + ": ")
+
+	flag.Var(&servFail, "servfail", "on resolver failure (not NXDOMAIN), `mode` fallback, retry (then fail) or fail")
+	flag.Var(&fanout, "fanout", "how to start connection attempts: `mode` stagger (see -stagger), all (at once), sequential (one at a time) or fastest (lowest connect latency)")
+	flag.BoolFunc("parallel", "dial every target at once, short for -fanout all", func(string) error {
+		fanout = srvdial.FanoutAll
+		return nil
+	})
+	flag.BoolFunc("sequential", "dial one target at a time, short for -fanout sequential", func(string) error {
+		fanout = srvdial.FanoutSequential
+		return nil
+	})
+	flag.BoolFunc("fastest", "dial every target at once, and keep the quickest to connect, short for -fanout fastest", func(string) error {
+		fanout = srvdial.FanoutFastest
+		return nil
+	})
+	flag.Var(&requireSoftware, "require-software", "only accept servers whose banner's software version contains `string`; may be repeated, for any of them")
+	flag.Var(&rejectSoftware, "reject-software", "refuse servers whose banner's software version contains `string`; may be repeated")
+	flag.StringVar(expect, "peek-string", "", "same as -expect `prefix`")
+	flag.Func("ipqos", "mark the connection with DSCP `value` as for ssh's IPQoS (af21, ef, lowdelay, cs1, a number...)", func(s string) (err error) {
+		ipqos, err = srvdial.ParseIPQoS(s)
+		return err
+	})
+	flag.Func("fwmark", "set firewall mark `n` on outgoing sockets, for policy routing (SO_MARK, Linux only; needs CAP_NET_ADMIN or CAP_NET_RAW)", func(s string) error {
+		n, err := strconv.ParseUint(s, 0, 32)
+		fwmark = uint32(n)
+		return err
+	})
+	flag.Func("timeout", "give up on connecting after `seconds` (or a duration like 1m30s), as for ssh's ConnectTimeout (default: $"+connectTimeoutEnv+", or 60)", func(s string) (err error) {
+		overallTimeout, err = parseTimeout(s)
+		return err
+	})
+	flag.Func("resolve", "connect to `host:port:addr[,addr...]` at those addresses instead of resolving it, as with curl --resolve; may be repeated", func(s string) error {
+		key, ips, err := parseResolve(s)
+		if err != nil {
+			return err
+		}
+		if resolveTo == nil {
+			resolveTo = map[string][]net.IPAddr{}
+		}
+		resolveTo[key] = ips
+		return nil
+	})
+	flag.Var(&prefer, "prefer", "try SRV targets under DNS `suffix` first, such as the local site's; may be repeated")
+	flag.Var(&excludes, "exclude", "skip SRV targets matching `pattern` (a glob, or /regexp/); may be repeated")
+	flag.Var(&proxyHeader, "proxy-header", "add `header` (\"Name: value\") to HTTP proxy requests; may be repeated")
+}
+
+var (
+	handoffFD  = flag.Int("fd", 1, "pass the connected socket over file descriptor `n`")
+	relayMode  = flag.Bool("relay", false, "relay data over stdin/stdout instead of passing the socket")
+	sendToPath = flag.String("send-to", "", "pass the connected socket to the Unix socket at `path`")
+	execCmd    = flag.String("exec", "", "run `command` with the connected socket as its stdin and stdout")
+	forwardTo  = flag.String("W", "", "connect to `host:port`, as with ssh -W")
+
+	service   = flag.String("service", "ssh", "look up _`name`._PROTO SRV records, for other services with -exec or -relay")
+	proto     = flag.String("proto", "tcp", "look up _SERVICE._`proto` SRV records")
+	expect    = flag.String("expect", "", "require banners to start with `prefix` (default: SSH-2 for -service ssh, other services aren't peeked)")
+	peekRegex = flag.String("peek-regex", "", "require banner lines to match `regexp`, instead of -expect")
+	minProto  = flag.String("min-protocol", "", "refuse servers whose banner announces a protocol older than `version`, like 2.0")
+	peekCmd   = flag.String("peek-cmd", "", "also accept targets only if `command` exits 0, run with the socket (to MSG_PEEK at) as stdin")
+	noPeek    = flag.Bool("no-peek", false, "don't wait for a banner, for servers that only speak once the client has")
+
+	overallTimeout  time.Duration
+	resolveTo       map[string][]net.IPAddr
+	servFail        srvdial.ServFailMode
+	fanout          srvdial.FanoutMode
+	dnsTimeout      = flag.Duration("dns-timeout", 0, "give up on each DNS lookup after `duration` (default: the resolver's own timeouts)")
+	useMDNS         = flag.Bool("mdns", true, "look up names under .local with multicast DNS, as Avahi and Bonjour advertise them")
+	debugDNS        = flag.Bool("debug-dns", false, "log each DNS query and its response (with Go's own resolver, which reads /etc/resolv.conf)")
+	connectTimeout  = flag.Duration("connect-timeout", 0, "give up on each connection attempt after `duration` (default: only the overall 1m deadline)")
+	maxParallel     = flag.Int("max-parallel", 0, "have at most `n` connection attempts in flight at once (default: no limit)")
+	peekTimeout     = flag.Duration("peek-timeout", peekTimeoutDefault, "give up on a target that hasn't sent its banner within `duration` (0 for no limit)")
+	maxTargets      = flag.Int("max-targets", 0, "only try the first `n` SRV targets, once ordered (default: all of them)")
+	retries         = flag.Int("retries", 0, "if no SRV target could be connected to, try them all up to `n` more times")
+	retryBackoffArg = flag.Duration("retry-backoff", srvdial.DefaultRetryBackoff, "wait about `duration` before the first retry, doubling each time")
+	stagger         = flag.Duration("stagger", srvdial.DefaultStagger, "wait `duration` before trying the next target of the same priority")
+	priorityStagger = flag.Duration("stagger-priority", 0, "wait `duration` before trying the next priority (default: same as -stagger)")
+	addrOrder       = flag.String("addr-order", "resolver", "try each target's addresses in the `order` the resolver gives them, or by the rules of rfc6724 (see -gai-conf)")
+	gaiConf         = flag.String("gai-conf", gaiConfDefault, "with -addr-order rfc6724, take address precedences and labels from `file`, as getaddrinfo does")
+
+	keepAlive      = flag.Duration("keepalive", 0, "send TCP keepalives once the connection has been idle for `interval`, and then as often (negative to turn them off; default: Go's 15s)")
+	keepAliveCount = flag.Int("keepalive-count", 0, "drop the connection after `n` unanswered keepalives (default: the kernel's, usually 9)")
+	userTimeout    = flag.Duration("user-timeout", 0, "drop the connection once data sent on it has gone unacknowledged for `duration` (TCP_USER_TIMEOUT, Linux only)")
+	noDelay        = flag.Bool("nodelay", true, "keep TCP_NODELAY set, as Go does; -nodelay=false turns Nagle's algorithm back on, for bulk transfers")
+	sendBuffer     = flag.Int("sndbuf", 0, "set the socket's send buffer to `bytes` (SO_SNDBUF; default: autotuned by the kernel)")
+	receiveBuffer  = flag.Int("rcvbuf", 0, "set the socket's receive buffer to `bytes` (SO_RCVBUF; default: autotuned by the kernel)")
+	ipqos          int
+	fwmark         uint32
+	mptcp          = flag.Bool("mptcp", false, "ask for Multipath TCP, so sessions can survive path changes, falling back to TCP where the kernel or server doesn't support it")
+	fastOpen       = flag.Bool("fastopen", false, "use TCP Fast Open where the client speaks first (with -proxy, -proxy-protocol, -tls or -ws), saving a round trip on repeat connections (Linux only)")
+	iface          = flag.String("interface", "", "only connect out of the network interface or VRF `name`, for SRV targets and fallback alike (SO_BINDTODEVICE on Linux, IP_BOUND_IF on macOS)")
+
+	proxyURL    = flag.String("proxy", "", "connect through the proxy at `url` (socks5:// or http://[user:pass@]host:port)")
+	proxyHeader stringsFlag
+	proxyProto  = flag.String("proxy-protocol", "", "send a HAProxy PROXY protocol header of `version` v1 or v2 on connect")
+	torSOCKS    = flag.String("tor-socks", srvdial.DefaultTorSOCKS, "connect to .onion targets through Tor's SOCKS port at `host:port`")
+	useTLS      = flag.Bool("tls", false, "wrap connections in TLS before speaking SSH (implies -relay)")
+	tlsName     = flag.String("tls-servername", "", "use `name` for TLS SNI and verification (default: the target's name)")
+	tlsCert     = flag.String("tls-cert", "", "present the client certificate in `file`")
+	tlsKey      = flag.String("tls-key", "", "use the client certificate key in `file`")
+	tlsCA       = flag.String("tls-ca", "", "also trust the CA certificates in `file`")
+	muxPrefix   = flag.String("mux-prefix", "", "treat a banner line starting with `prefix` as a multiplexer gateway (see -mux-send)")
+	muxSendRaw  = flag.String("mux-send", "", "send `data` (with Go escapes like \\r\\n) in reply to a -mux-prefix line")
+	wsURL       = flag.String("ws", "", "tunnel over the WebSocket at `url` (ws:// or wss://, %h and %p expanded), or \"dns\" for the _ssh-ws TXT record (implies -relay)")
+	jump        = flag.Bool("jump", false, "reach HOSTNAME through the jump host in its _ssh-jump._tcp record, if any")
+
+	aliasDir       = flag.String("hostkeyalias", "", "write an ssh_config snippet setting HostKeyAlias to the chosen target to `dir`/HOSTNAME.conf (needs -target or -sticky)")
+	checkSSHFP     = flag.Bool("sshfp", false, "refuse SRV targets whose host keys don't match their DNSSEC validated SSHFP records (uses ssh-keyscan)")
+	noFallback     = flag.Bool("no-fallback", false, "fail instead of connecting to HOSTNAME when it has no SRV records")
+	fallbackAlways = flag.Bool("fallback-always", false, "also fall back to HOSTNAME when no SRV target could be connected to")
+	interactive    = flag.Bool("interactive", false, "with several SRV targets, ask on the terminal which one to connect to")
+	targetTo       = flag.String("target", "", "connect to the SRV target `host:port` (as from list), skipping target selection")
+	fallbackTo     = flag.String("fallback-host", "", "fall back to `host[:port],...` instead of HOSTNAME, racing them if more than one")
+
+	crossZone       = flag.Bool("cross-zone", false, "allow SRV targets outside the DNS zone of HOSTNAME")
+	policyFile      = flag.String("policy", "", "apply block/rewrite rules from `file` to SRV answers, and its srv rules instead of (or with) DNS")
+	excludes        stringsFlag
+	requireSoftware stringsFlag
+	rejectSoftware  stringsFlag
+	prefer          stringsFlag
+	cooldown        = flag.Duration("cooldown", srvdial.DefaultCooldown, "with -state, try targets that failed in the last `duration` after all the others")
+	seed            = flag.Uint64("seed", 0, "order targets of the same priority by weight with random `seed`, for reproducible runs")
+	sticky          = flag.Bool("sticky", false, "with -state, try the target last connected to for the same hostname first")
+	stateFile       = flag.String("state", srvdial.DefaultStatePath(), "remember targets' connect latencies in `file`, and try the fastest first (\"\" to disable)")
+	reportUsage     = flag.Bool("report-usage", false, "log CPU time, peak RSS, sockets and DNS lookups at exit")
+	showSummary     = flag.Bool("summary", false, "log a one-line timing summary at exit: SRV lookup, each target tried, the winner, and time to handoff")
+)
+
+// stringsFlag is a flag.Value collecting each occurrence of a flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), introText, os.Args[0])
+	flag.PrintDefaults()
+}
+
+// errUsage is returned by run when the command line is malformed, and the
+// usage text should be shown.
+var errUsage = errors.New("usage")
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if *debugDNS {
+		srvdial.TraceDNS(log.Printf)
+	}
+
+	err := run()
+	if *reportUsage {
+		reportStats()
+	}
+
+	var ee *exec.ExitError
+	switch {
+	case err == nil:
+	case err == errUsage:
+		usage()
+		os.Exit(exitFailure)
+	case errors.As(err, &ee):
+		os.Exit(ee.ExitCode())
+	default:
+		log.Print(err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode returns the exit status for an error from run, with the most
+// specific cause first.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, srvdial.ErrHandoff):
+		return exitHandoff
+	case errors.Is(err, errCancelled):
+		return exitCancelled
+	case errors.Is(err, srvdial.ErrTimeout):
+		return exitTimeout
+	case errors.Is(err, srvdial.ErrPeekMismatch):
+		return exitPeekMismatch
+	case errors.Is(err, srvdial.ErrAllTargetsFailed):
+		return exitUnreachable
+	case errors.Is(err, srvdial.ErrNoSRVRecords):
+		return exitNoRecords
+	default:
+		return exitFailure
+	}
+}
+
+// parseFallbacks parses -fallback-host's comma-separated hosts, each with
+// an optional port, defaulting to port.
+func parseFallbacks(s, port string) ([]srvdial.Fallback, error) {
+	var fallbacks []srvdial.Fallback
+	for _, hostPort := range strings.Split(s, ",") {
+		fb := srvdial.Fallback{Host: strings.TrimSpace(hostPort), Port: port}
+		if h, p, err := net.SplitHostPort(fb.Host); err == nil {
+			if fb.Port, err = parsePort(p); err != nil {
+				return nil, err
+			}
+			fb.Host = h
+		}
+		var err error
+		if fb.Host, err = parseHost(fb.Host); err != nil {
+			return nil, err
+		}
+		fallbacks = append(fallbacks, fb)
+	}
+	return fallbacks, nil
+}
+
+// connectTimeoutEnv, if set, is the default for -timeout, so that it can be
+// set alongside ssh's ConnectTimeout, which ssh has no ProxyCommand token
+// for.
+const connectTimeoutEnv = "SSH_CONNECT_TIMEOUT"
+
+// parseTimeout parses a -timeout: a number of seconds, as ConnectTimeout
+// takes in ssh_config, or a duration.
+func parseTimeout(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if n, nerr := strconv.ParseUint(s, 10, 32); nerr == nil {
+		d, err = time.Duration(n)*time.Second, nil
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q: want a positive number of seconds, or a duration like 1m30s", s)
+	}
+	return d, nil
+}
+
+// connectDeadline returns how long connecting may take, from the SRV lookup
+// to the handoff: -timeout, or else $SSH_CONNECT_TIMEOUT, or else
+// srvdial.DefaultTimeout.
+func connectDeadline() (time.Duration, error) {
+	if overallTimeout > 0 {
+		return overallTimeout, nil
+	}
+	if s := os.Getenv(connectTimeoutEnv); s != "" {
+		d, err := parseTimeout(s)
+		if err != nil {
+			return 0, fmt.Errorf("$%s: %w", connectTimeoutEnv, err)
+		}
+		return d, nil
+	}
+	return srvdial.DefaultTimeout, nil
+}
+
+// dialFallbacks races the fallback hosts, with the addresses from lookups
+// where there are any. A host whose lookup failed is left out, unless they
+// all failed.
+func dialFallbacks(ctx context.Context, sd *srvdial.SRVDialer, fallbacks []srvdial.Fallback, lookups []func() ([]net.IPAddr, error)) (net.Conn, error) {
+	var hostPorts []string
+	for _, fb := range fallbacks {
+		hostPorts = append(hostPorts, net.JoinHostPort(fb.Host, fb.Port))
+	}
+	log.Print("Fallback to non-SRV: ", strings.Join(hostPorts, ", "))
+
+	var resolved []srvdial.Fallback
+	var errs []error
+	for i, fb := range fallbacks {
+		if lookups[i] != nil {
+			// otherwise, it's dialled by name, for the proxy (or Tor, or TLS)
+			ips, err := lookups[i]()
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			fb.IPs = ips
+		}
+		resolved = append(resolved, fb)
+	}
+	if len(resolved) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	for _, err := range errs {
+		log.Print(err)
+	}
+	return sd.DialFallbacks(ctx, resolved)
+}
+
+// socketOptions returns the options for the winning socket from the command
+// line flags.
+func socketOptions() srvdial.SocketOptions {
+	return srvdial.SocketOptions{
+		KeepAlive:      *keepAlive,
+		KeepAliveCount: *keepAliveCount,
+		UserTimeout:    *userTimeout,
+		Nagle:          !*noDelay,
+		SendBuffer:     *sendBuffer,
+		ReceiveBuffer:  *receiveBuffer,
+		TOS:            ipqos,
+		Mark:           fwmark,
+		Interface:      *iface,
+	}
+}
+
+// newSRVDialer returns an SRVDialer configured from the command line flags.
+func newSRVDialer() (*srvdial.SRVDialer, error) {
+	if *retries < 0 {
+		return nil, fmt.Errorf("invalid -retries %d", *retries)
+	}
+	if *retryBackoffArg < 0 {
+		return nil, fmt.Errorf("invalid -retry-backoff %s: must not be negative", *retryBackoffArg)
+	}
+	muxSend, err := strconv.Unquote(`"` + *muxSendRaw + `"`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -mux-send %q: %w", *muxSendRaw, err)
+	}
+
+	sd := &srvdial.SRVDialer{
+		ServFail:        servFail,
+		DNSTimeout:      *dnsTimeout,
+		MaxParallel:     *maxParallel,
+		MaxTargets:      *maxTargets,
+		PeekTimeout:     *peekTimeout,
+		ConnectTimeout:  *connectTimeout,
+		Cooldown:        *cooldown,
+		Resolve:         resolveTo,
+		PreResolve:      *proxyURL == "" && !*useTLS,
+		Sticky:          *sticky,
+		SameZone:        !*crossZone,
+		Seed:            *seed,
+		Prefer:          prefer,
+		Retries:         *retries,
+		RetryBackoff:    *retryBackoffArg,
+		Fanout:          fanout,
+		Stagger:         *stagger,
+		PriorityStagger: *priorityStagger,
+		Track:           track,
+		Zone:            *iface,
+	}
+	if *useMDNS {
+		sd.Resolver = srvdial.MDNSResolver{}
+	}
+	var expectRE *regexp.Regexp
+	if *peekRegex != "" {
+		if expectRE, err = regexp.Compile(*peekRegex); err != nil {
+			return nil, fmt.Errorf("invalid -peek-regex: %w", err)
+		}
+	}
+	switch {
+	case *noPeek && (*expect != "" || expectRE != nil):
+		return nil, errors.New("-no-peek and -expect (or -peek-regex) don't make sense together")
+	case *expect != "" && expectRE != nil:
+		return nil, errors.New("use one of -expect and -peek-regex")
+	case *noPeek:
+		// the first target to accept the connection wins
+	case *service == "ssh" || *expect != "" || expectRE != nil:
+		sd.Peek = (&srvdial.SSHPeek{
+			Expect:          *expect,
+			ExpectRE:        expectRE,
+			MuxPrefix:       *muxPrefix,
+			MuxSend:         muxSend,
+			MinProto:        *minProto,
+			RequireSoftware: requireSoftware,
+			RejectSoftware:  rejectSoftware,
+		}).Peek
+	}
+	if *peekCmd != "" {
+		builtin := sd.Peek
+		cp := &cmdPeek{Command: *peekCmd, Timeout: *peekTimeout}
+		sd.Peek = func(conn net.Conn) error {
+			if builtin != nil {
+				if err := builtin(conn); err != nil {
+					return err
+				}
+			}
+			return cp.Peek(conn)
+		}
+	}
+	if *checkSSHFP {
+		// ssh-keyscan connects on its own, to where the target's connection went:
+		if *proxyURL != "" || *useTLS || *wsURL != "" || *jump {
+			return nil, errors.New("-sshfp can't be used with -proxy, -tls, -ws or -jump")
+		}
+		sd.Verify = srvdial.VerifySSHFP
+	}
+	// -exclude comes first, so that it wins over the rules file:
+	if sd.Policy, err = srvdial.ExcludePolicy(excludes); err != nil {
+		return nil, err
+	}
+	if *policyFile != "" {
+		p, err := srvdial.LoadPolicy(*policyFile)
+		if err != nil {
+			return nil, err
+		}
+		sd.Policy = append(sd.Policy, p...)
+	}
+	if *stateFile != "" {
+		if sd.State, err = srvdial.LoadState(*stateFile); err != nil {
+			// only an optimisation, so don't let it get in the way:
+			log.Print("Ignoring state: ", err)
+		}
+	}
+
+	switch *addrOrder {
+	case "resolver":
+	case "rfc6724":
+		sd.AddrPolicy, err = srvdial.LoadGAIConf(*gaiConf)
+		if errors.Is(err, fs.ErrNotExist) && *gaiConf == gaiConfDefault {
+			sd.AddrPolicy, err = srvdial.DefaultAddrPolicy(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid -addr-order %q (want resolver or rfc6724)", *addrOrder)
+	}
+
+	opts := socketOptions()
+	if err := opts.Check(); err != nil {
+		return nil, err
+	}
+	// Fast Open defers connecting until the first write, which SSH
+	// itself would leave us waiting on, as the server speaks first:
+	if *proxyURL != "" || *proxyProto != "" || *useTLS || *wsURL != "" {
+		opts.FastOpen = *fastOpen
+	} else if *fastOpen {
+		log.Print("Ignoring -fastopen, as SSH servers speak first (see -proxy, -proxy-protocol, -tls, -ws)")
+	}
+	nd := &net.Dialer{Control: opts.Control}
+	nd.SetMultipathTCP(*mptcp)
+	var d srvdial.ContextDialer = nd
+	if *proxyURL != "" {
+		if d, err = srvdial.NewProxyDialer(*proxyURL, proxyHeader, d); err != nil {
+			return nil, err
+		}
+	}
+	// Tor is reached directly, not through -proxy, but with the same
+	// socket options:
+	sd.Dialer = srvdial.NewOnionDialer(*torSOCKS, nd, d)
+	if *proxyProto != "" {
+		if sd.Dialer, err = srvdial.NewProxyProtoDialer(*proxyProto, sd.Dialer); err != nil {
+			return nil, err
+		}
+	}
+	if *useTLS {
+		if sd.Dialer, err = srvdial.NewTLSDialer(*tlsName, *tlsCert, *tlsKey, *tlsCA, sd.Dialer); err != nil {
+			return nil, err
+		}
+	}
+
+	return sd, nil
+}
+
+// subcommand returns the subcommand named by the first argument, or "" if
+// it's a HOSTNAME: when a PORT follows it, as from ProxyCommand %h %p for a
+// host called "list", or when the arguments come after "--".
+func subcommand() string {
+	if flag.NArg() == 0 || os.Args[len(os.Args)-flag.NArg()-1] == "--" {
+		return ""
+	}
+	if flag.NArg() == 2 {
+		if _, err := parsePort(flag.Arg(1)); err == nil {
+			return ""
+		}
+	}
+	return flag.Arg(0)
+}
+
+func run() (err error) {
+	if flag.NArg() < 1 && *forwardTo == "" {
+		return errUsage
+	}
+	if *handoffFD < 0 {
+		return fmt.Errorf("invalid -fd %d", *handoffFD)
+	}
+
+	switch subcommand() {
+	case "preheat":
+		if flag.NArg() != 2 {
+			return errUsage
+		}
+		host, err := parseHost(flag.Arg(1))
+		if err != nil {
+			return err
+		}
+		return preheat(host, os.Args[1:len(os.Args)-flag.NArg()])
+	case "mosh":
+		return runMosh(flag.Args()[1:])
+	case "banner":
+		if flag.NArg() != 2 {
+			return errUsage
+		}
+		host, err := parseHost(flag.Arg(1))
+		if err != nil {
+			return err
+		}
+		return runBanner(host)
+	case "list":
+		if flag.NArg() != 2 {
+			return errUsage
+		}
+		host, err := parseHost(flag.Arg(1))
+		if err != nil {
+			return err
+		}
+		return runList(host)
+	case "browse":
+		if flag.NArg() != 2 {
+			return errUsage
+		}
+		domain, err := parseHost(flag.Arg(1))
+		if err != nil {
+			return err
+		}
+		return runBrowse(domain)
+	case "genconfig":
+		if flag.NArg() != 2 {
+			return errUsage
+		}
+		domain, err := parseHost(flag.Arg(1))
+		if err != nil {
+			return err
+		}
+		return runGenConfig(domain, os.Args[1:len(os.Args)-flag.NArg()])
+	case "install":
+		return runInstall(os.Args[1:len(os.Args)-flag.NArg()], flag.Args()[1:])
+	case "doctor":
+		if flag.NArg() > 2 {
+			return errUsage
+		}
+		var host string
+		if flag.NArg() == 2 {
+			if host, err = parseHost(flag.Arg(1)); err != nil {
+				return err
+			}
+		}
+		return runDoctor(host)
+	case "wrap":
+		if flag.NArg() != 2 {
+			return errUsage
+		}
+		return runWrap(flag.Arg(1), os.Args[1:len(os.Args)-flag.NArg()])
+	case "test-env":
+		return runTestEnv(os.Args[1:len(os.Args)-flag.NArg()], flag.Args()[1:])
+	}
+
+	args, err := compatArgs(*forwardTo, flag.Args())
+	if err != nil {
+		return err
+	}
+	host, err := parseHost(args[0])
+	if err != nil {
+		return err
+	}
+	fallbackPort := "22"
+	if len(args) >= 2 {
+		if fallbackPort, err = parsePort(args[1]); err != nil {
+			return err
+		}
+	} else if *service != "ssh" {
+		p, err := net.LookupPort(*proto, *service)
+		if err != nil {
+			return fmt.Errorf("no PORT given, and %w", err)
+		}
+		fallbackPort = strconv.Itoa(p)
+	}
+
+	if *useTLS && !*relayMode {
+		log.Print("TLS connections can't be passed on, relaying instead")
+		*relayMode = true
+	}
+	if *wsURL != "" && !*relayMode {
+		log.Print("WebSocket tunnels can't be passed on, relaying instead")
+		*relayMode = true
+	}
+	if !*relayMode && *execCmd == "" && *sendToPath == "" {
+		if ok, err := fdpass.IsSocket(*handoffFD); err == nil && !ok {
+			log.Printf("fd %d is not a socket (ProxyUseFdPass=no?), relaying instead", *handoffFD)
+			*relayMode = true
+		}
+	}
+
+	sd, err := newSRVDialer()
+	if err != nil {
+		return err
+	}
+
+	// the alias is only read by ssh on the next connection, so that has to
+	// be to the same target:
+	if *aliasDir != "" && *targetTo == "" && (!*sticky || *stateFile == "") {
+		return errors.New("-hostkeyalias needs -target, or -sticky with -state")
+	}
+	if *noFallback && *fallbackAlways {
+		return errors.New("-no-fallback and -fallback-always can't be used together")
+	}
+	// IP addresses (link-local ones with a %zone too) never have SRV
+	// records, and .onion names are never looked up, so those are always
+	// connected to directly:
+	_, ipErr := netip.ParseAddr(host)
+	direct := ipErr == nil || srvdial.IsOnion(host)
+	fallbacks := []srvdial.Fallback{{Host: host, Port: fallbackPort}}
+	if *fallbackTo != "" && !direct {
+		if fallbacks, err = parseFallbacks(*fallbackTo, fallbackPort); err != nil {
+			return fmt.Errorf("invalid -fallback-host: %w", err)
+		}
+	}
+
+	// Picking is up to a person, so it's done before the deadline starts:
+	var picked *net.SRV
+	switch {
+	case *targetTo != "":
+		if *wsURL != "" || *jump {
+			return errors.New("-target can't be used with -ws or -jump")
+		}
+		if picked, err = parseTarget(*targetTo); err != nil {
+			return fmt.Errorf("invalid -target: %w", err)
+		}
+		log.Printf("Connecting to %s (see -target)", srvdial.TargetKey(picked))
+	case *interactive && !direct && *wsURL == "" && !*jump:
+		if picked, err = pickTarget(sd, host); err != nil {
+			return err
+		}
+	}
+
+	// One deadline covers everything up to the handoff, fallback included:
+	timeout, err := connectDeadline()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeoutCause(context.Background(), timeout, srvdial.ErrTimeout)
+	defer cancel()
+	ctx, stop := cancelOnSignal(ctx)
+	defer stop()
+	defer dumpOnSignal(ctx)()
+	ctx, flushTraces := startTracing(ctx)
+	defer flushTraces()
+	ctx, span := startSpan(ctx, "ssh-srv", attribute.String("srv.name", host))
+	defer func() { endSpan(span, err) }()
+	sd.Tracer = tracer
+
+	var winner string
+	var ready time.Duration // until the connection was handed over
+	if *showSummary {
+		r := sd.Resolver
+		if r == nil {
+			r = net.DefaultResolver
+		}
+		sd.Resolver = timedResolver{r}
+		defer func() { log.Print(summary(winner, ready)) }()
+	}
+
+	// Resolve the fallback hosts ahead of time, unless that's left to a
+	// proxy, or TLS needs the name:
+	fallbackAddrs := make([]func() ([]net.IPAddr, error), len(fallbacks))
+	for i, fb := range fallbacks {
+		if _, ok := sd.ResolveOverride(fb.Host, fb.Port); ok {
+			continue // DialFallbacks takes them from -resolve
+		}
+		if (direct || !*noFallback) && *proxyURL == "" && !srvdial.IsOnion(fb.Host) && !*useTLS && *wsURL == "" {
+			fallbackAddrs[i] = sd.LookupAhead(ctx, fb.Host)
+		}
+	}
+
+	var c net.Conn
+	var target *net.SRV
+	srvTried := false
+	err = srvdial.ErrNoJump
+	switch {
+	case *wsURL != "":
+		c, err = sd.DialWebSocket(ctx, *wsURL, host, fallbackPort)
+	case *jump && !direct:
+		c, err = sd.DialJump(ctx, host, fallbackPort)
+	}
+	switch {
+	case err != srvdial.ErrNoJump:
+		// connected via a jump host or WebSocket, or failed trying
+	case picked != nil:
+		var sc srvdial.Conn
+		if sc, err = sd.DialTarget(ctx, *proto, picked); err != nil {
+			err = fmt.Errorf("%w: %s: %w", srvdial.ErrAllTargetsFailed, srvdial.TargetKey(picked), err)
+		}
+		c, target = sc.Conn, sc.Target
+		// a -target is meant, so isn't fallen back from:
+		srvTried = *targetTo == ""
+	case srvdial.IsOnion(host):
+		err = fmt.Errorf("%w: not looking up %s in DNS", srvdial.ErrSRVLookup, host)
+	case direct:
+		err = fmt.Errorf("%w: %s is an IP address", srvdial.ErrSRVLookup, host)
+	default:
+		var sc srvdial.Conn
+		sc, err = sd.DialSRVContext(ctx, *service, *proto, host)
+		c, target = sc.Conn, sc.Target
+		srvTried = true
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, srvdial.ErrSRVLookup) && (direct || !*noFallback):
+		case *fallbackAlways && srvTried && ctx.Err() == nil:
+			log.Print(err)
+		default:
+			return cancelled(ctx, err)
+		}
+		if c, err = dialFallbacks(ctx, sd, fallbacks, fallbackAddrs); err != nil {
+			return cancelled(ctx, err)
+		}
+	}
+	stop() // a signal now should kill us, as it would ssh
+	log.Print("DialSRV handed us ", c.RemoteAddr())
+	winner = c.RemoteAddr().String()
+	if target != nil {
+		winner = srvdial.TargetKey(target)
+	}
+	if err := socketOptions().Apply(c); err != nil {
+		log.Print("Setting socket options: ", err)
+	}
+	if tc, ok := c.(*net.TCPConn); ok && *mptcp {
+		if ok, _ := tc.MultipathTCP(); ok {
+			log.Print("Using Multipath TCP")
+		} else {
+			log.Print("Not using Multipath TCP, as the kernel or server doesn't support it")
+		}
+	}
+	if sd.Peek != nil {
+		if banner := srvdial.ServerBanner(c); banner != "" {
+			log.Printf("Server banner: %q", banner)
+		}
+	}
+
+	if *aliasDir != "" {
+		previous, err := recordTarget(*aliasDir, host, target)
+		switch {
+		case err != nil:
+			log.Print("Failed recording HostKeyAlias: ", err)
+		case target == nil:
+		case previous != "" && previous != hostKeyAlias(target):
+			log.Printf("Connected to %s, but ssh checks the host key as %s this time, so expect a key mismatch (see -hostkeyalias)",
+				hostKeyAlias(target), previous)
+		default:
+			log.Print("Suggested HostKeyAlias ", hostKeyAlias(target))
+		}
+	}
+
+	if *execCmd != "" {
+		ready = time.Since(startTime)
+		return execWith(*execCmd, c)
+	}
+
+	if *relayMode {
+		ready = time.Since(startTime)
+		return relay(c)
+	}
+
+	// the receiver gets its own copy of the descriptor, so ours is closed
+	// once it's been passed:
+	defer c.Close()
+
+	_, handoff := startSpan(ctx, "handoff")
+	if *sendToPath != "" {
+		handoff.SetAttributes(attribute.String("handoff.path", *sendToPath))
+		err := srvdial.HandoffTo(c, *sendToPath, handoffTimeout)
+		endSpan(handoff, err)
+		if err != nil {
+			return err
+		}
+		ready = time.Since(startTime)
+		log.Print("Socket handed to ", *sendToPath)
+		return nil
+	}
+
+	handoff.SetAttributes(attribute.Int("handoff.fd", *handoffFD))
+	err = srvdial.Handoff(c, *handoffFD, handoffTimeout)
+	endSpan(handoff, err)
+	if err != nil {
+		return err
+	}
+	ready = time.Since(startTime)
+
+	log.Printf("Socket handed to fd %d", *handoffFD)
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"jeremy.visser.name/go/ssh-srv/pkg/srvdial"
+)
+
+// runMosh implements "ssh-srv mosh". mosh can't take a passed descriptor,
+// so instead the SRV targets are raced as usual, and mosh is run against
+// the winning target and port.
+func runMosh(args []string) error {
+	fs := flag.NewFlagSet("mosh", flag.ExitOnError)
+	printOnly := fs.Bool("print", false, "print the mosh command instead of running it")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s mosh [-print] [USER@]HOSTNAME [MOSH-OPTIONS...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	user, host, ok := strings.Cut(fs.Arg(0), "@")
+	if !ok {
+		user, host = "", user
+	}
+	host, err := parseHost(host)
+	if err != nil {
+		return err
+	}
+
+	sd, err := newSRVDialer()
+	if err != nil {
+		return err
+	}
+
+	timeout, err := connectDeadline()
+	if err != nil {
+		return err
+	}
+	argv := []string{"mosh"}
+	target := host
+	ctx, cancel := context.WithTimeoutCause(context.Background(), timeout, srvdial.ErrTimeout)
+	defer cancel()
+	ctx, stop := cancelOnSignal(ctx)
+	defer stop()
+	defer dumpOnSignal(ctx)()
+
+	sc, err := sd.DialSRVContext(ctx, "ssh", "tcp", host)
+	switch {
+	case err == nil:
+		sc.Close()
+		target = strings.TrimSuffix(sc.Target.Target, ".")
+		argv = append(argv, fmt.Sprintf("--ssh=ssh -p %d", sc.Target.Port))
+	case errors.Is(err, srvdial.ErrSRVLookup) && ctx.Err() == nil:
+		log.Print("No SRV records, running mosh against ", host)
+	default:
+		return cancelled(ctx, err)
+	}
+	stop()
+	if user != "" {
+		target = user + "@" + target
+	}
+	argv = append(append(argv, fs.Args()[1:]...), target)
+
+	if *printOnly {
+		fmt.Println(shellJoin(argv))
+		return nil
+	}
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, argv, os.Environ())
+}
+
+// shellJoin quotes args for a POSIX shell, where needed.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if a != "" && strings.Trim(a, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:@%+,") == "" {
+			quoted[i] = a
+		} else {
+			quoted[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"jeremy.visser.name/go/ssh-srv/pkg/srvdial"
+)
+
+// pickTarget implements -interactive, asking on the terminal which of
+// host's SRV targets to connect to. ssh owns stdin and stdout, so it's
+// asked on /dev/tty. It returns nil to race them all as usual: when the
+// user says so, or there's nothing to choose between, or no terminal.
+func pickTarget(sd *srvdial.SRVDialer, host string) (*net.SRV, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		log.Print("No terminal for -interactive, racing the targets as usual: ", err)
+		return nil, nil
+	}
+	defer tty.Close()
+
+	ctx, cancel := context.WithTimeoutCause(context.Background(), srvdial.DefaultTimeout, srvdial.ErrTimeout)
+	defer cancel()
+	// only what DialSRVContext would try is offered, as -target skips it all:
+	cname, addrs, err := sd.Targets(ctx, *service, *proto, host)
+	if err != nil {
+		// left to the usual path, to fall back or fail as it would
+		return nil, nil
+	}
+	if len(addrs) < 2 {
+		return nil, nil
+	}
+	slices.SortStableFunc(addrs, func(a, b *net.SRV) int {
+		return cmp.Or(cmp.Compare(a.Priority, b.Priority), cmp.Compare(b.Weight, a.Weight))
+	})
+
+	fmt.Fprintf(tty, "SRV targets for %s:\n", strings.TrimSuffix(cname, "."))
+	for i, addr := range addrs {
+		fmt.Fprintf(tty, "  %d) %s (prio %d, weight %d)\n", i+1, srvdial.TargetKey(addr), addr.Priority, addr.Weight)
+	}
+	r := bufio.NewReader(tty)
+	for {
+		fmt.Fprintf(tty, "Connect to [1-%d, or Enter to race them all]: ", len(addrs))
+		line, err := r.ReadString('\n')
+		if err != nil {
+			fmt.Fprintln(tty)
+			return nil, fmt.Errorf("reading choice of target: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return nil, nil
+		}
+		if n, err := strconv.Atoi(line); err == nil && n >= 1 && n <= len(addrs) {
+			log.Printf("Picked %s", srvdial.TargetKey(addrs[n-1]))
+			return addrs[n-1], nil
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+
+	"jeremy.visser.name/go/ssh-srv/pkg/srvdial"
+)
+
+// preheatChildEnv marks the detached child started by preheat.
+const preheatChildEnv = "SSH_SRV_PREHEAT_CHILD"
+
+// preheat starts resolving host's SRV records and target addresses in a
+// detached background process, then returns immediately. It is meant for
+// ssh_config "Match exec" hooks, so caching resolvers (systemd-resolved,
+// nscd, dnsmasq and friends) already hold the answers by the time the real
+// ProxyCommand runs. The child is given the same flags, so it looks up what
+// -service, -proto and -mdns would have the ProxyCommand look up, within
+// -timeout and -dns-timeout.
+func preheat(host string, flags []string) error {
+	if os.Getenv(preheatChildEnv) != "" {
+		return preheatLookup(host)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, append(slices.Clone(flags), "preheat", host)...)
+	cmd.Env = append(os.Environ(), preheatChildEnv+"=1")
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+func preheatLookup(host string) error {
+	sd, err := newSRVDialer()
+	if err != nil {
+		return err
+	}
+	timeout, err := connectDeadline()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeoutCause(context.Background(), timeout, srvdial.ErrTimeout)
+	defer cancel()
+
+	var r srvdial.Resolver = net.DefaultResolver
+	if sd.Resolver != nil {
+		r = sd.Resolver
+	}
+	_, addrs, err := sd.LookupSRV(ctx, *service, *proto, host)
+	if err != nil {
+		// the fallback path will look up the host itself:
+		r.LookupIPAddr(ctx, host)
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.LookupIPAddr(ctx, addr.Target)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
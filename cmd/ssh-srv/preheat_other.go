@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// detach does nothing here: there are no sessions to leave.
+func detach(cmd *exec.Cmd) {}
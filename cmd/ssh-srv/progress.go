@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progress records each connection attempt, for the SIGUSR1 dump.
+var progress struct {
+	mu       sync.Mutex
+	attempts []*attempt
+}
+
+type attempt struct {
+	name  string
+	start time.Time
+	end   time.Time // zero while in flight
+	err   error
+}
+
+// track records the start of an attempt to connect to name, returning a
+// func to call with its outcome.
+func track(name string) (done func(error)) {
+	a := &attempt{name: name, start: time.Now()}
+	progress.mu.Lock()
+	progress.attempts = append(progress.attempts, a)
+	progress.mu.Unlock()
+	return func(err error) {
+		progress.mu.Lock()
+		defer progress.mu.Unlock()
+		a.end, a.err = time.Now(), err
+	}
+}
+
+// dumpProgress describes every attempt so far, and how long is left until
+// ctx's deadline.
+func dumpProgress(ctx context.Context, start time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Progress after %s", time.Since(start).Round(time.Millisecond))
+	if deadline, ok := ctx.Deadline(); ok {
+		fmt.Fprintf(&b, ", %s left", time.Until(deadline).Round(time.Millisecond))
+	}
+	if err := context.Cause(ctx); err != nil {
+		fmt.Fprintf(&b, " (%s)", err)
+	}
+
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	if len(progress.attempts) == 0 {
+		b.WriteString(": nothing tried yet")
+	} else {
+		b.WriteString(":\n")
+	}
+	for _, a := range progress.attempts {
+		switch {
+		case a.end.IsZero():
+			fmt.Fprintf(&b, "  %s: in flight for %s\n", a.name, time.Since(a.start).Round(time.Millisecond))
+		case a.err != nil:
+			fmt.Fprintf(&b, "  %s: failed after %s: %s\n", a.name, a.end.Sub(a.start).Round(time.Millisecond), a.err)
+		default:
+			fmt.Fprintf(&b, "  %s: connected after %s\n", a.name, a.end.Sub(a.start).Round(time.Millisecond))
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// dumpOnSignal logs dumpProgress on SIGUSR1, for seeing what a connection
+// that seems hung is waiting on, until stop is called.
+func dumpOnSignal(ctx context.Context) (stop func()) {
+	start := time.Now()
+	sigs := make(chan os.Signal, 1)
+	notifyProgress(sigs)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-sigs:
+				log.Print(dumpProgress(ctx, start))
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(sigs)
+			close(done)
+		})
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// notifyProgress does nothing here, as there's no SIGUSR1.
+func notifyProgress(c chan<- os.Signal) {}
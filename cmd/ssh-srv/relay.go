@@ -0,0 +1,21 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+)
+
+// relay copies data between stdin/stdout and conn, for clients that can't
+// take ownership of a passed descriptor. It returns once the remote end
+// has closed the connection.
+func relay(conn net.Conn) error {
+	go func() {
+		io.Copy(conn, os.Stdin)
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+	}()
+	_, err := io.Copy(os.Stdout, conn)
+	return err
+}
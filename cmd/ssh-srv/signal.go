@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"jeremy.visser.name/go/ssh-srv/pkg/srvdial"
+)
+
+// errCancelled is the cause of a context cancelled by cancelOnSignal.
+var errCancelled = errors.New("cancelled by user")
+
+// cancelOnSignal returns a copy of parent that's cancelled on SIGINT or
+// SIGTERM, so the dials in flight are abandoned and their sockets closed,
+// rather than left to time out after ssh has gone. Another signal, or any
+// once stop is called, has its usual effect.
+func cancelOnSignal(parent context.Context) (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigs:
+			signal.Stop(sigs)
+			cancel(fmt.Errorf("%w (%s)", errCancelled, sig))
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			signal.Stop(sigs)
+			close(done)
+		})
+	}
+}
+
+// cancelled returns why ctx was cancelled instead of err, if it was by
+// cancelOnSignal, since err is then mostly noise from the abandoned dials.
+// If ctx ran out of time instead, err is wrapped in srvdial.ErrTimeout.
+func cancelled(ctx context.Context, err error) error {
+	cause := context.Cause(ctx)
+	switch {
+	case errors.Is(cause, errCancelled):
+		return cause
+	case errors.Is(ctx.Err(), context.DeadlineExceeded) && !errors.Is(err, srvdial.ErrTimeout):
+		return fmt.Errorf("%w: %w", srvdial.ErrTimeout, err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"log"
+
+	"jeremy.visser.name/go/ssh-srv/pkg/srvdial"
+)
+
+// reportStats logs the CPU time and peak RSS of the process along with the
+// counters in srvdial.Stats.
+func reportStats() {
+	user, sys, maxrss, err := rusage()
+	if err != nil {
+		log.Print(err)
+		log.Printf("Usage: %d sockets, %d DNS lookups",
+			srvdial.Stats.Sockets.Load(), srvdial.Stats.DNSLookups.Load())
+		return
+	}
+	log.Printf("Usage: user %s, sys %s, peak RSS %.1f MiB, %d sockets, %d DNS lookups",
+		user, sys, float64(maxrss)/(1<<20), srvdial.Stats.Sockets.Load(), srvdial.Stats.DNSLookups.Load())
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// rusage is not supported on this platform.
+func rusage() (user, sys time.Duration, maxrss int64, err error) {
+	return 0, 0, 0, fmt.Errorf("Getrusage: %w", errors.ErrUnsupported)
+}
@@ -0,0 +1,27 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// rusage returns the CPU time the process has used, and its peak RSS in
+// bytes.
+func rusage() (user, sys time.Duration, maxrss int64, err error) {
+	var ru unix.Rusage
+	if err := unix.Getrusage(unix.RUSAGE_SELF, &ru); err != nil {
+		return 0, 0, 0, fmt.Errorf("Getrusage: %w", err)
+	}
+
+	// ru_maxrss is in kilobytes, except on Darwin where it's in bytes:
+	maxrss = int64(ru.Maxrss) * 1024
+	if runtime.GOOS == "darwin" {
+		maxrss = int64(ru.Maxrss)
+	}
+	return time.Duration(ru.Utime.Nano()), time.Duration(ru.Stime.Nano()), maxrss, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"jeremy.visser.name/go/ssh-srv/pkg/srvdial"
+)
+
+// startTime is when ssh-srv started, for -summary.
+var startTime = time.Now()
+
+// srvLookups adds up the time spent on SRV lookups, for -summary.
+var srvLookups struct {
+	mu    sync.Mutex
+	total time.Duration
+	n     int
+}
+
+// timedResolver is a srvdial.Resolver adding the time its SRV lookups take
+// to srvLookups.
+type timedResolver struct {
+	srvdial.Resolver
+}
+
+func (r timedResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	start := time.Now()
+	cname, addrs, err := r.Resolver.LookupSRV(ctx, service, proto, name)
+	srvLookups.mu.Lock()
+	srvLookups.total += time.Since(start)
+	srvLookups.n++
+	srvLookups.mu.Unlock()
+	return cname, addrs, err
+}
+
+// LookupRaw passes raw lookups (see srvdial.LookupRaw) on untimed, as
+// they're not SRV lookups.
+func (r timedResolver) LookupRaw(ctx context.Context, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	return srvdial.LookupRaw(ctx, r.Resolver, name, qtype)
+}
+
+// LookupTXT passes TXT lookups (see srvdial.LookupTXT) on untimed.
+func (r timedResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return srvdial.LookupTXT(ctx, r.Resolver, name)
+}
+
+// ValidatesDNSSEC says what srvdial.ValidatesDNSSEC does of the wrapped
+// resolver.
+func (r timedResolver) ValidatesDNSSEC() bool {
+	return srvdial.ValidatesDNSSEC(r.Resolver)
+}
+
+// summary describes a run on one line: the SRV lookup time, each attempt
+// (from track) and how long it took, the winner, if any, and how long
+// until the connection was handed over (zero if it never was).
+func summary(winner string, ready time.Duration) string {
+	var b strings.Builder
+	b.WriteString("Summary: ")
+	srvLookups.mu.Lock()
+	if srvLookups.n > 0 {
+		fmt.Fprintf(&b, "SRV lookup %s, ", srvLookups.total.Round(10*time.Microsecond))
+	}
+	srvLookups.mu.Unlock()
+
+	progress.mu.Lock()
+	if len(progress.attempts) == 1 {
+		b.WriteString("1 target tried")
+	} else {
+		fmt.Fprintf(&b, "%d targets tried", len(progress.attempts))
+	}
+	var parts []string
+	for _, a := range progress.attempts {
+		took := a.end.Sub(a.start).Round(10 * time.Microsecond)
+		switch {
+		case a.end.IsZero():
+			parts = append(parts, a.name+" unfinished")
+		case errors.Is(a.err, context.Canceled):
+			parts = append(parts, fmt.Sprintf("%s abandoned after %s", a.name, took))
+		case a.err != nil:
+			parts = append(parts, fmt.Sprintf("%s failed after %s", a.name, took))
+		default:
+			parts = append(parts, fmt.Sprintf("%s %s", a.name, took))
+		}
+	}
+	progress.mu.Unlock()
+	if len(parts) > 0 {
+		fmt.Fprintf(&b, " (%s)", strings.Join(parts, ", "))
+	}
+
+	switch {
+	case winner == "":
+		fmt.Fprintf(&b, ", no winner, gave up after %s", time.Since(startTime).Round(time.Millisecond))
+	case ready == 0:
+		fmt.Fprintf(&b, ", winner %s, not handed over", winner)
+	default:
+		fmt.Fprintf(&b, ", winner %s, handed over after %s", winner, ready.Round(time.Millisecond))
+	}
+	return b.String()
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/sys/unix"
+
+	"jeremy.visser.name/go/ssh-srv/pkg/srvdial"
+)
+
+// testEnvChildEnv marks the sandboxed child started by runTestEnv.
+const testEnvChildEnv = "SSH_SRV_TESTENV_CHILD"
+
+// testEnvHost is the hostname every scenario connects to.
+const testEnvHost = "host.test"
+
+// resolvConf is replaced in the sandbox, pointing at the fake DNS server.
+const resolvConf = "/etc/resolv.conf"
+
+// A testListener stands in for one sshd.
+type testListener struct {
+	Port  int
+	Mode  string        // "ssh", "http" (wrong protocol), or "silent"
+	Delay time.Duration // before sending the banner
+}
+
+func (l testListener) banner() string {
+	switch l.Mode {
+	case "ssh":
+		return fmt.Sprintf("SSH-2.0-testenv-%d\r\n", l.Port)
+	case "http":
+		return "HTTP/1.0 400 Bad Request\r\n\r\n"
+	}
+	return ""
+}
+
+// A testScenario is one end-to-end run against the fake DNS and listeners.
+// Every name in .test resolves to 127.0.0.1.
+type testScenario struct {
+	Name      string
+	SRV       []net.SRV // the _ssh._tcp records for testEnvHost
+	Listeners []testListener
+	Port      string // the port argument, for the fallback
+	Want      int    // port of the listener that should win, or 0 to fail
+}
+
+var testScenarios = []testScenario{
+	{
+		Name:      "priority",
+		SRV:       []net.SRV{{Target: "a.test.", Port: 2201, Priority: 0}, {Target: "b.test.", Port: 2202, Priority: 1}},
+		Listeners: []testListener{{Port: 2201, Mode: "ssh"}, {Port: 2202, Mode: "ssh"}},
+		Want:      2201,
+	},
+	{
+		Name:      "refused",
+		SRV:       []net.SRV{{Target: "a.test.", Port: 2201, Priority: 0}, {Target: "b.test.", Port: 2202, Priority: 1}},
+		Listeners: []testListener{{Port: 2202, Mode: "ssh"}},
+		Want:      2202,
+	},
+	{
+		Name:      "slow",
+		SRV:       []net.SRV{{Target: "a.test.", Port: 2201, Priority: 0}, {Target: "b.test.", Port: 2202, Priority: 1}},
+		Listeners: []testListener{{Port: 2201, Mode: "ssh", Delay: 3 * time.Second}, {Port: 2202, Mode: "ssh"}},
+		Want:      2202,
+	},
+	{
+		Name:      "silent",
+		SRV:       []net.SRV{{Target: "a.test.", Port: 2201, Priority: 0}, {Target: "b.test.", Port: 2202, Priority: 1}},
+		Listeners: []testListener{{Port: 2201, Mode: "silent"}, {Port: 2202, Mode: "ssh"}},
+		Want:      2202,
+	},
+	{
+		Name:      "wrong-protocol",
+		SRV:       []net.SRV{{Target: "a.test.", Port: 2201, Priority: 0}, {Target: "b.test.", Port: 2202, Priority: 1}},
+		Listeners: []testListener{{Port: 2201, Mode: "http"}, {Port: 2202, Mode: "ssh"}},
+		Want:      2202,
+	},
+	{
+		Name:      "fallback",
+		Listeners: []testListener{{Port: 2222, Mode: "ssh"}},
+		Port:      "2222",
+		Want:      2222,
+	},
+	{
+		Name: "all-down",
+		SRV:  []net.SRV{{Target: "a.test.", Port: 2201, Priority: 0}, {Target: "b.test.", Port: 2202, Priority: 1}},
+		Want: 0,
+	},
+}
+
+// runTestEnv implements "ssh-srv test-env". It re-executes itself in
+// throwaway user, network and mount namespaces, where a fake DNS server
+// and scripted listeners stand in for the real world, then runs ssh-srv
+// (with flags, if any) through each scenario named in args, or all of them.
+// Nothing outside the sandbox is touched.
+func runTestEnv(flags, args []string) error {
+	fs := flag.NewFlagSet("test-env", flag.ExitOnError)
+	verbose := fs.Bool("v", false, "show ssh-srv's log for passing scenarios too")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [OPTIONS] test-env [-v] [SCENARIO...]\n\nscenarios:", os.Args[0])
+		for _, sc := range testScenarios {
+			fmt.Fprint(fs.Output(), " ", sc.Name)
+		}
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if os.Getenv(testEnvChildEnv) == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		cmd := exec.Command(exe, append(append(flags, "test-env"), args...)...)
+		cmd.Env = append(os.Environ(), testEnvChildEnv+"=1")
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Cloneflags: syscall.CLONE_NEWNET | syscall.CLONE_NEWNS,
+		}
+		if os.Getuid() != 0 {
+			cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+			cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+			cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+		}
+		return cmd.Run()
+	}
+
+	if err := testEnvSetup(); err != nil {
+		return fmt.Errorf("test-env: %w", err)
+	}
+	var current atomic.Pointer[testScenario]
+	if err := serveTestDNS(&current); err != nil {
+		return fmt.Errorf("test-env: %w", err)
+	}
+
+	selected := testScenarios
+	if fs.NArg() > 0 {
+		selected = nil
+		for _, name := range fs.Args() {
+			i := indexScenario(name)
+			if i < 0 {
+				return fmt.Errorf("test-env: unknown scenario %q", name)
+			}
+			selected = append(selected, testScenarios[i])
+		}
+	}
+
+	failed := 0
+	for i := range selected {
+		sc := &selected[i]
+		current.Store(sc)
+		out, err := sc.run(flags)
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %s\n", sc.Name, err)
+		} else {
+			fmt.Printf("ok   %s\n", sc.Name)
+		}
+		if err != nil || *verbose {
+			os.Stdout.Write(out)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("test-env: %d of %d scenarios failed", failed, len(selected))
+	}
+	return nil
+}
+
+func indexScenario(name string) int {
+	for i, sc := range testScenarios {
+		if sc.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// testEnvSetup brings up the loopback interface and points the resolver
+// at the fake DNS server, from inside the sandbox.
+func testEnvSetup() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return fmt.Errorf("lo: %w", err)
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr); err != nil {
+		return fmt.Errorf("lo: %w", err)
+	}
+
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("mount: %w", err)
+	}
+	name := filepath.Join(os.TempDir(), fmt.Sprintf("ssh-srv-testenv-%d.conf", os.Getpid()))
+	if err := os.WriteFile(name, []byte("nameserver 127.0.0.1\n"), 0o644); err != nil {
+		return err
+	}
+	// the bind mount keeps the file alive after it's unlinked:
+	defer os.Remove(name)
+	if err := unix.Mount(name, resolvConf, "", unix.MS_BIND, ""); err != nil {
+		return fmt.Errorf("mount %s: %w", resolvConf, err)
+	}
+	return nil
+}
+
+// serveTestDNS answers for the scenario in current on 127.0.0.1:53.
+func serveTestDNS(current *atomic.Pointer[testScenario]) error {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:53")
+	if err != nil {
+		return err
+	}
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp, err := current.Load().answer(buf[:n]); err == nil {
+				pc.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return nil
+}
+
+func (sc *testScenario) answer(query []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.ToLower(q.Name.String())
+	rh := dnsmessage.Header{ID: h.ID, Response: true, Authoritative: true, RecursionDesired: h.RecursionDesired}
+	if !strings.HasSuffix(name, ".test.") {
+		rh.RCode = dnsmessage.RCodeNameError
+	}
+	b := dnsmessage.NewBuilder(nil, rh)
+	b.StartQuestions()
+	b.Question(q)
+	b.StartAnswers()
+	hdr := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 1}
+	switch {
+	case q.Type == dnsmessage.TypeSRV && name == "_ssh._tcp."+testEnvHost+".":
+		for _, srv := range sc.SRV {
+			target, err := dnsmessage.NewName(srv.Target)
+			if err != nil {
+				return nil, err
+			}
+			b.SRVResource(hdr, dnsmessage.SRVResource{
+				Priority: srv.Priority, Weight: srv.Weight, Port: srv.Port, Target: target,
+			})
+		}
+	case q.Type == dnsmessage.TypeA && rh.RCode == dnsmessage.RCodeSuccess:
+		b.AResource(hdr, dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}})
+	case q.Type == dnsmessage.TypeSOA:
+		// everything is in the one zone, test.:
+		b.StartAuthorities()
+		b.SOAResource(dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("test."), Class: dnsmessage.ClassINET, TTL: 1},
+			dnsmessage.SOAResource{NS: dnsmessage.MustNewName("ns.test."), MBox: dnsmessage.MustNewName("root.test."), Serial: 1, MinTTL: 1})
+	}
+	return b.Finish()
+}
+
+// run starts the scenario's listeners and runs ssh-srv against them,
+// returning its log.
+func (sc *testScenario) run(flags []string) ([]byte, error) {
+	for _, l := range sc.Listeners {
+		ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(l.Port)))
+		if err != nil {
+			return nil, err
+		}
+		defer ln.Close()
+		go l.serve(ln)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*srvdial.DefaultTimeout)
+	defer cancel()
+	args := append(append([]string{}, flags...), "-relay", testEnvHost)
+	if sc.Port != "" {
+		args = append(args, sc.Port)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, exe, args...)
+	cmd.Env = os.Environ()[:0:0]
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, testEnvChildEnv+"=") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	runErr := cmd.Run()
+
+	line, _ := bufio.NewReader(&stdout).ReadString('\n')
+	got, _ := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "SSH-2.0-testenv-")))
+	switch {
+	case sc.Want == 0 && runErr == nil:
+		err = fmt.Errorf("want failure, got port %d", got)
+	case sc.Want != 0 && runErr != nil:
+		err = fmt.Errorf("want port %d, got %v", sc.Want, runErr)
+	case got != sc.Want:
+		err = fmt.Errorf("want port %d, got port %d", sc.Want, got)
+	}
+	return stderr.Bytes(), err
+}
+
+func (l testListener) serve(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Print("test-env: ", err)
+			}
+			return
+		}
+		go func() {
+			defer c.Close()
+			time.Sleep(l.Delay)
+			io.WriteString(c, l.banner())
+			io.Copy(io.Discard, c)
+		}()
+	}
+}
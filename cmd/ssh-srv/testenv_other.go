@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// runTestEnv needs Linux namespaces.
+func runTestEnv(flags, args []string) error {
+	return errors.New("test-env is only supported on Linux")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracingFlushTimeout bounds sending the spans on at exit.
+const tracingFlushTimeout = 5 * time.Second
+
+// tracer is set by startTracing when spans are exported, and nil otherwise.
+var tracer trace.Tracer
+
+// startTracing exports spans over OTLP/HTTP if an endpoint is configured
+// the way OpenTelemetry SDKs are (OTEL_EXPORTER_OTLP_ENDPOINT, or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT), under any parent span passed in
+// TRACEPARENT by whatever runs ssh-srv. The returned func sends off what's
+// been recorded.
+func startTracing(ctx context.Context) (context.Context, func()) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return ctx, func() {}
+	}
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		log.Print("Tracing: ", err)
+	}))
+	exp, err := otlptracehttp.New(ctx)
+	if err != nil {
+		log.Print("Tracing: ", err)
+		return ctx, func() {}
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the name:
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "ssh-srv")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK())
+	if err != nil {
+		log.Print("Tracing: ", err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	tracer = tp.Tracer("jeremy.visser.name/go/ssh-srv")
+
+	carrier := propagation.MapCarrier{
+		"traceparent": os.Getenv("TRACEPARENT"),
+		"tracestate":  os.Getenv("TRACESTATE"),
+	}
+	ctx = propagation.TraceContext{}.Extract(ctx, carrier)
+	return ctx, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+		defer cancel()
+		if err := tp.Shutdown(ctx); err != nil {
+			log.Print("Tracing: ", err)
+		}
+	}
+}
+
+// startSpan starts a span from tracer, if tracing is on.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if tracer == nil {
+		return ctx, noop.Span{}
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends span, recording err if it failed.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
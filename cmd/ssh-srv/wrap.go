@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// wrapEnv maps each tool supported by "ssh-srv wrap" to the environment
+// variable it takes its ssh command from.
+var wrapEnv = map[string]string{
+	"git":   "GIT_SSH_COMMAND",
+	"rsync": "RSYNC_RSH",
+}
+
+// runWrap implements "ssh-srv wrap TOOL", printing a shell command that
+// makes tools which spawn ssh themselves go through ssh-srv, for use as:
+//
+//	eval "$(ssh-srv wrap git)"
+//
+// Any other flags given to ssh-srv are passed on to the ProxyCommand.
+func runWrap(tool string, flags []string) error {
+	env, ok := wrapEnv[tool]
+	if !ok {
+		return fmt.Errorf("wrap: unsupported tool %q (want git or rsync)", tool)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	proxyCommand := shellJoin(append(append([]string{exe}, flags...), "%h", "%p"))
+	sshCommand := shellJoin([]string{
+		"ssh",
+		"-o", "ProxyUseFdPass=yes",
+		"-o", "ProxyCommand=" + proxyCommand,
+	})
+	fmt.Printf("export %s=%s\n", env, shellJoin([]string{sshCommand}))
+	return nil
+}
@@ -0,0 +1,5 @@
+// Package fdpass passes file descriptors to other processes over Unix
+// sockets, using SCM_RIGHTS ancillary data, along with the few other
+// descriptor operations that go with it, so that its callers build where
+// there are no Unix sockets.
+package fdpass
@@ -0,0 +1,54 @@
+//go:build !unix
+
+package fdpass
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Send is not supported on this platform.
+func Send(sock, fd int, timeout time.Duration) error {
+	return errors.ErrUnsupported
+}
+
+// SendConn is not supported on this platform.
+func SendConn(c *net.UnixConn, fd int) error {
+	return errors.ErrUnsupported
+}
+
+// Check is not supported on this platform.
+func Check(sock int) error {
+	return errors.ErrUnsupported
+}
+
+// IsSocket is not supported on this platform.
+func IsSocket(fd int) (bool, error) {
+	return false, errors.ErrUnsupported
+}
+
+// Receive is not supported on this platform.
+func Receive(sock int) (int, error) {
+	return -1, errors.ErrUnsupported
+}
+
+// Socketpair is not supported on this platform.
+func Socketpair() ([2]int, error) {
+	return [2]int{-1, -1}, errors.ErrUnsupported
+}
+
+// SetBlocking is not supported on this platform.
+func SetBlocking(fd int, blocking bool) error {
+	return errors.ErrUnsupported
+}
+
+// Dup is not supported on this platform.
+func Dup(fd int) (int, error) {
+	return -1, errors.ErrUnsupported
+}
+
+// Close is not supported on this platform.
+func Close(fd int) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,82 @@
+//go:build unix
+
+package fdpass
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSendReceive(t *testing.T) {
+	fds, err := Socketpair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Close(fds[0])
+	defer Close(fds[1])
+	if err := Check(fds[0]); err != nil {
+		t.Errorf("Check of a socketpair: %s", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	peer, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	f, err := c.(*net.TCPConn).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := Send(fds[0], int(f.Fd()), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := Receive(fds[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := IsSocket(fd); !ok || err != nil {
+		t.Errorf("IsSocket of the descriptor received = %v, %v", ok, err)
+	}
+
+	// what's written on the descriptor received arrives at the peer
+	received := os.NewFile(uintptr(fd), "received")
+	defer received.Close()
+	if _, err := received.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(peer, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("peer read %q, %v", buf, err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if ok, err := IsSocket(int(w.Fd())); ok || err != nil {
+		t.Errorf("IsSocket of a pipe = %v, %v", ok, err)
+	}
+	if err := Check(int(w.Fd())); err == nil {
+		t.Error("Check of a pipe succeeded")
+	}
+}
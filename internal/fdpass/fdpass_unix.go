@@ -0,0 +1,144 @@
+//go:build unix
+
+package fdpass
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Send passes fd over the Unix socket sock, giving up after timeout. A
+// single zero byte is sent as the regular payload, which is what OpenSSH's
+// ProxyUseFdPass expects. sock is left open.
+func Send(sock, fd int, timeout time.Duration) error {
+	if err := Check(sock); err != nil {
+		return err
+	}
+	// a dup, so that closing it leaves sock alone:
+	dup, err := Dup(sock)
+	if err != nil {
+		return err
+	}
+	f := os.NewFile(uintptr(dup), fmt.Sprintf("fd %d", sock))
+	defer f.Close()
+	c, err := net.FileConn(f)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("fd %d is not a Unix socket", sock)
+	}
+	if err := uc.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	return SendConn(uc, fd)
+}
+
+// SendConn passes fd over c, like Send, within c's write deadline if any.
+func SendConn(c *net.UnixConn, fd int) error {
+	rights := unix.UnixRights(fd)
+	n, oobn, err := c.WriteMsgUnix([]byte{0}, rights, nil)
+	if err == nil && (n != 1 || oobn != len(rights)) {
+		err = io.ErrShortWrite
+	}
+	return err
+}
+
+// Check reports why sock can't be used to pass descriptors, if it can't:
+// it must be a Unix socket, of type SOCK_STREAM or SOCK_DGRAM.
+func Check(sock int) error {
+	typ, err := unix.GetsockoptInt(sock, unix.SOL_SOCKET, unix.SO_TYPE)
+	if err != nil {
+		return fmt.Errorf("fd %d: %w", sock, err)
+	}
+	if typ != unix.SOCK_STREAM && typ != unix.SOCK_DGRAM {
+		return fmt.Errorf("fd %d is a socket of type %d, not SOCK_STREAM or SOCK_DGRAM", sock, typ)
+	}
+	// unnamed sockets (as from socketpair) don't have an address on every
+	// platform, so only an address of another family is conclusive:
+	if sa, err := unix.Getsockname(sock); err == nil {
+		if _, ok := sa.(*unix.SockaddrUnix); !ok {
+			return fmt.Errorf("fd %d is not a Unix socket", sock)
+		}
+	}
+	return nil
+}
+
+// IsSocket reports whether fd refers to a socket. ssh only gives a
+// ProxyCommand a socket when ProxyUseFdPass is enabled; otherwise fd 1 is
+// a pipe, and passing a descriptor over it is bound to fail.
+func IsSocket(fd int) (bool, error) {
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return false, err
+	}
+	return st.Mode&unix.S_IFMT == unix.S_IFSOCK, nil
+}
+
+// Receive receives a descriptor passed over the Unix socket sock, as by
+// Send. Any others that came with it are closed.
+func Receive(sock int) (int, error) {
+	buf, oob := make([]byte, 1), make([]byte, unix.CmsgSpace(4))
+	_, oobn, _, _, err := unix.Recvmsg(sock, buf, oob, 0)
+	if err != nil {
+		return -1, fmt.Errorf("recvmsg: %w", err)
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		return -1, fmt.Errorf("no descriptor received: %v", err)
+	}
+	fds, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil {
+		return -1, err
+	}
+	if len(fds) == 0 {
+		return -1, fmt.Errorf("no descriptor received")
+	}
+	for _, fd := range fds[1:] {
+		unix.Close(fd)
+	}
+	return fds[0], nil
+}
+
+// Socketpair returns a connected pair of Unix stream sockets, close-on-exec.
+func Socketpair() ([2]int, error) {
+	// no SOCK_CLOEXEC on macOS:
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		return fds, fmt.Errorf("socketpair: %w", err)
+	}
+	unix.CloseOnExec(fds[0])
+	unix.CloseOnExec(fds[1])
+	return fds, nil
+}
+
+// SetBlocking puts fd in blocking mode, as receivers of a descriptor (and
+// children given one as stdin) expect, or back in non-blocking mode, as
+// the runtime's poller expects.
+func SetBlocking(fd int, blocking bool) error {
+	return unix.SetNonblock(fd, !blocking)
+}
+
+// Dup returns a close-on-exec duplicate of fd.
+func Dup(fd int) (int, error) {
+	dup, err := unix.FcntlInt(uintptr(fd), unix.F_DUPFD_CLOEXEC, 0)
+	if err != nil {
+		return -1, fmt.Errorf("dup: %w", err)
+	}
+	return dup, nil
+}
+
+// Close closes fd.
+func Close(fd int) error {
+	return unix.Close(fd)
+}
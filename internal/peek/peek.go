@@ -0,0 +1,8 @@
+// Package peek reads from a socket without consuming the data, so that the
+// socket can later be handed to another process with its buffer intact.
+package peek
+
+import "errors"
+
+// ErrWouldBlock is returned by Try when no data is waiting yet.
+var ErrWouldBlock = errors.New("peek: no data waiting")
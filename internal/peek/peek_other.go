@@ -0,0 +1,13 @@
+//go:build !unix
+
+package peek
+
+import "errors"
+
+// Supported reports whether Try works on this platform.
+const Supported = false
+
+// Try is not supported on this platform.
+func Try(fd int, buf []byte) (int, error) {
+	return 0, errors.ErrUnsupported
+}
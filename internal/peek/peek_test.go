@@ -0,0 +1,75 @@
+//go:build unix
+
+package peek
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTry(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	peer, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	rc, err := c.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	try := func(buf []byte) (n int, err error) {
+		if cerr := rc.Control(func(fd uintptr) { n, err = Try(int(fd), buf) }); cerr != nil {
+			t.Fatal(cerr)
+		}
+		return n, err
+	}
+
+	buf := make([]byte, 64)
+	if n, err := try(buf); err != ErrWouldBlock {
+		t.Errorf("Try with nothing sent = %d, %v; want ErrWouldBlock", n, err)
+	}
+
+	const banner = "SSH-2.0-test\r\n"
+	if _, err := peer.Write([]byte(banner)); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if n, err = try(buf); err != ErrWouldBlock || time.Now().After(deadline) {
+			break
+		}
+	}
+	if err != nil || string(buf[:n]) != banner {
+		t.Fatalf("Try = %q, %v; want %q", buf[:n], err, banner)
+	}
+
+	// still there to read
+	got := make([]byte, len(banner))
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(c, got); err != nil || string(got) != banner {
+		t.Errorf("read %q after peeking, %v", got, err)
+	}
+
+	peer.Close()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if n, err = try(buf); err != ErrWouldBlock || time.Now().After(deadline) {
+			break
+		}
+	}
+	if n != 0 || err != nil {
+		t.Errorf("Try after the peer closed = %d, %v; want 0, nil", n, err)
+	}
+}
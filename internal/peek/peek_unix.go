@@ -0,0 +1,28 @@
+//go:build unix
+
+package peek
+
+import "golang.org/x/sys/unix"
+
+// Supported reports whether Try works on this platform.
+const Supported = true
+
+// Try fills buf with whatever data is waiting on the non-blocking socket
+// fd, without waiting for more. It returns ErrWouldBlock if there's nothing
+// yet, or 0 bytes if the peer has closed the connection. The data remains
+// queued on the socket.
+func Try(fd int, buf []byte) (int, error) {
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, unix.MSG_PEEK)
+		switch err {
+		case unix.EINTR:
+			continue
+		case unix.EAGAIN:
+			return 0, ErrWouldBlock
+		case nil:
+			return n, nil
+		}
+		// n is -1, and a connect that Fast Open deferred fails here, too:
+		return 0, err
+	}
+}
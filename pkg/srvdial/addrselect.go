@@ -0,0 +1,239 @@
+package srvdial
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"math/bits"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// An AddrPolicy is the policy table for RFC 6724 destination address
+// selection. Each address has the precedence and label of the longest
+// prefix it's in, with IPv4 addresses taken as IPv4-mapped IPv6 ones.
+type AddrPolicy struct {
+	Precedence []PrefixValue
+	Label      []PrefixValue
+}
+
+// A PrefixValue is one row of an AddrPolicy table.
+type PrefixValue struct {
+	Prefix netip.Prefix
+	Value  int
+}
+
+// DefaultAddrPolicy returns the default policy table from RFC 6724,
+// section 2.1, which prefers IPv6 over IPv4, and native addresses over
+// transition technologies like 6to4 and Teredo.
+func DefaultAddrPolicy() *AddrPolicy {
+	rows := []struct {
+		prefix            string
+		precedence, label int
+	}{
+		{"::1/128", 50, 0},
+		{"::/0", 40, 1},
+		{"::ffff:0:0/96", 35, 4},
+		{"2002::/16", 30, 2},
+		{"2001::/32", 5, 5},
+		{"fc00::/7", 3, 13},
+		{"::/96", 1, 3},
+		{"fec0::/10", 1, 11},
+		{"3ffe::/16", 1, 12},
+	}
+	p := &AddrPolicy{}
+	for _, r := range rows {
+		prefix := netip.MustParsePrefix(r.prefix)
+		p.Precedence = append(p.Precedence, PrefixValue{prefix, r.precedence})
+		p.Label = append(p.Label, PrefixValue{prefix, r.label})
+	}
+	return p
+}
+
+// LoadGAIConf reads the precedence and label lines of a gai.conf(5) file,
+// such as /etc/gai.conf:
+//
+//	precedence ::ffff:0:0/96 100
+//	label 2001:db8::/32 20
+//
+// As with glibc, any precedence lines replace the default precedence table
+// altogether, and likewise label lines the label table. Other lines, like
+// scopev4 and reload, are ignored.
+func LoadGAIConf(name string) (*AddrPolicy, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var precedence, label []PrefixValue
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line, _, _ := strings.Cut(s.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 || (fields[0] != "precedence" && fields[0] != "label") {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: invalid line: %q", name, n, line)
+		}
+		prefix, err := netip.ParsePrefix(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, n, err)
+		}
+		if prefix.Addr().Is4() {
+			// glibc only takes IPv6 prefixes; be kind to IPv4 ones:
+			prefix = netip.PrefixFrom(netip.AddrFrom16(prefix.Addr().As16()), prefix.Bits()+96)
+		}
+		v, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid %s %q", name, n, fields[0], fields[2])
+		}
+		if fields[0] == "precedence" {
+			precedence = append(precedence, PrefixValue{prefix.Masked(), v})
+		} else {
+			label = append(label, PrefixValue{prefix.Masked(), v})
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	p := DefaultAddrPolicy()
+	if precedence != nil {
+		p.Precedence = precedence
+	}
+	if label != nil {
+		p.Label = label
+	}
+	return p, nil
+}
+
+// lookup returns the value of the longest prefix in table containing ip,
+// or 0 if there's none.
+func lookup(table []PrefixValue, ip netip.Addr) int {
+	ip = netip.AddrFrom16(ip.As16())
+	best, value := -1, 0
+	for _, row := range table {
+		if row.Prefix.Bits() > best && row.Prefix.Contains(ip) {
+			best, value = row.Prefix.Bits(), row.Value
+		}
+	}
+	return value
+}
+
+// SortAddrs sorts ips by the destination address selection rules of
+// RFC 6724, section 6, with the precedences and labels from p. Each
+// destination's source address is found by asking the kernel to route to
+// it, which sends nothing. Rules 3, 4 and 7, on the source addresses'
+// deprecation and home and temporary addresses, are left out, as the
+// kernel doesn't say. The input slice is not modified.
+func (p *AddrPolicy) SortAddrs(ips []net.IPAddr) []net.IPAddr {
+	type dest struct {
+		ip, src netip.Addr
+	}
+	dests := make([]dest, len(ips))
+	for i, ip := range ips {
+		dests[i].ip, _ = netip.AddrFromSlice(ip.IP)
+		dests[i].ip = dests[i].ip.Unmap().WithZone(ip.Zone)
+		dests[i].src = sourceAddr(ip)
+	}
+
+	order := make([]int, len(ips))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(i, j int) int {
+		da, db := dests[i], dests[j]
+		// Rule 1: avoid unusable destinations.
+		if c := cmp.Compare(boolRank(db.src.IsValid()), boolRank(da.src.IsValid())); c != 0 || !da.src.IsValid() {
+			return c
+		}
+		// Rule 2: prefer matching scope.
+		if c := cmp.Compare(boolRank(addrScope(db.ip) == addrScope(db.src)), boolRank(addrScope(da.ip) == addrScope(da.src))); c != 0 {
+			return c
+		}
+		// Rule 5: prefer matching label.
+		if c := cmp.Compare(boolRank(lookup(p.Label, db.ip) == lookup(p.Label, db.src)), boolRank(lookup(p.Label, da.ip) == lookup(p.Label, da.src))); c != 0 {
+			return c
+		}
+		// Rule 6: prefer higher precedence.
+		if c := cmp.Compare(lookup(p.Precedence, db.ip), lookup(p.Precedence, da.ip)); c != 0 {
+			return c
+		}
+		// Rule 8: prefer smaller scope.
+		if c := cmp.Compare(addrScope(da.ip), addrScope(db.ip)); c != 0 {
+			return c
+		}
+		// Rule 9: use longest matching prefix, for IPv6.
+		if da.ip.Is6() && db.ip.Is6() {
+			return cmp.Compare(commonPrefixLen(db.ip, db.src), commonPrefixLen(da.ip, da.src))
+		}
+		// Rule 10: otherwise, leave the order unchanged.
+		return 0
+	})
+
+	out := make([]net.IPAddr, len(ips))
+	for i, j := range order {
+		out[i] = ips[j]
+	}
+	return out
+}
+
+// sourceAddr returns the source address the kernel would use to reach ip,
+// or the zero Addr if it's unreachable.
+func sourceAddr(ip net.IPAddr) netip.Addr {
+	c, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: ip.IP, Zone: ip.Zone, Port: 9})
+	if err != nil {
+		return netip.Addr{}
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).AddrPort().Addr().Unmap()
+}
+
+// addrScope returns ip's scope, as in RFC 4291 and RFC 6724, section 3.2.
+func addrScope(ip netip.Addr) int {
+	const (
+		linkLocal = 0x2
+		siteLocal = 0x5
+		global    = 0xe
+	)
+	switch {
+	case ip.Is6() && ip.IsMulticast():
+		return int(ip.As16()[1] & 0xf)
+	case ip.IsLoopback(), ip.IsLinkLocalUnicast():
+		return linkLocal
+	case ip.Is6() && netip.MustParsePrefix("fec0::/10").Contains(ip):
+		return siteLocal
+	}
+	return global
+}
+
+// commonPrefixLen returns how many leading bits a and b share, up to the
+// 64 bits of an IPv6 subnet's prefix.
+func commonPrefixLen(a, b netip.Addr) int {
+	if !b.Is6() {
+		return 0
+	}
+	a16, b16 := a.As16(), b.As16()
+	n := 0
+	for i := 0; i < 8; i++ {
+		if x := a16[i] ^ b16[i]; x != 0 {
+			return n + bits.LeadingZeros8(x)
+		}
+		n += 8
+	}
+	return n
+}
+
+// boolRank is 1 for true, for comparing.
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
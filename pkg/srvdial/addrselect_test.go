@@ -0,0 +1,127 @@
+package srvdial
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAddrPolicyLookup(t *testing.T) {
+	p := DefaultAddrPolicy()
+	tests := []struct {
+		ip                string
+		precedence, label int
+	}{
+		{"::1", 50, 0},
+		{"2001:0:4136::1", 5, 5}, // Teredo
+		{"2001:db8::1", 40, 1},
+		{"2400:cb00::1", 40, 1},
+		{"192.0.2.1", 35, 4},
+		{"2002:c000:201::1", 30, 2},
+		{"fd00::1", 3, 13},
+	}
+	for _, tt := range tests {
+		ip := netip.MustParseAddr(tt.ip)
+		if got := lookup(p.Precedence, ip); got != tt.precedence {
+			t.Errorf("precedence of %s = %d, want %d", ip, got, tt.precedence)
+		}
+		if got := lookup(p.Label, ip); got != tt.label {
+			t.Errorf("label of %s = %d, want %d", ip, got, tt.label)
+		}
+	}
+}
+
+func TestLoadGAIConf(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "gai.conf")
+	conf := strings.Join([]string{
+		"# prefer IPv4",
+		"reload yes",
+		"precedence ::ffff:0:0/96 100",
+		"precedence ::/0 40 # the rest",
+		"precedence 10.0.0.0/8 110",
+		"scopev4 ::ffff:169.254.0.0/112 2",
+	}, "\n")
+	if err := os.WriteFile(name, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := LoadGAIConf(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]int{"192.0.2.1": 100, "10.1.2.3": 110, "::1": 40, "2001:db8::1": 40} {
+		if got := lookup(p.Precedence, netip.MustParseAddr(ip)); got != want {
+			t.Errorf("precedence of %s = %d, want %d", ip, got, want)
+		}
+	}
+	// without label lines, the labels are the default ones:
+	if got := lookup(p.Label, netip.MustParseAddr("192.0.2.1")); got != 4 {
+		t.Errorf("label of 192.0.2.1 = %d, want 4", got)
+	}
+
+	for _, bad := range []string{"precedence ::/0", "label ::/0 x", "precedence ::/200 1"} {
+		if err := os.WriteFile(name, []byte(bad), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadGAIConf(name); err == nil {
+			t.Errorf("LoadGAIConf of %q succeeded", bad)
+		}
+	}
+}
+
+func TestAddrScope(t *testing.T) {
+	for ip, want := range map[string]int{
+		"127.0.0.1":   0x2,
+		"169.254.1.1": 0x2,
+		"::1":         0x2,
+		"fe80::1":     0x2,
+		"fec0::1":     0x5,
+		"ff05::1":     0x5,
+		"192.0.2.1":   0xe,
+		"2001:db8::1": 0xe,
+	} {
+		if got := addrScope(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("addrScope(%s) = %#x, want %#x", ip, got, want)
+		}
+	}
+}
+
+func TestCommonPrefixLen(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"2001:db8::1", "2001:db8::2", 64},
+		{"2001:db8:0:1::1", "2001:db8:0:2::1", 62},
+		{"2001:db8::1", "3001:db8::1", 3},
+		{"2001:db8::1", "192.0.2.1", 0},
+	}
+	for _, tt := range tests {
+		if got := commonPrefixLen(netip.MustParseAddr(tt.a), netip.MustParseAddr(tt.b)); got != tt.want {
+			t.Errorf("commonPrefixLen(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSortAddrs(t *testing.T) {
+	v6 := net.IPAddr{IP: net.IPv6loopback}
+	v4 := net.IPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	if !sourceAddr(v6).IsValid() || !sourceAddr(v4).IsValid() {
+		t.Skip("no loopback route for both IPv4 and IPv6")
+	}
+	ips := []net.IPAddr{v4, v6}
+	if got := DefaultAddrPolicy().SortAddrs(ips); !got[0].IP.Equal(v6.IP) {
+		t.Errorf("SortAddrs = %v, want ::1 first by precedence", got)
+	}
+	if !ips[0].IP.Equal(v4.IP) {
+		t.Error("SortAddrs modified its input")
+	}
+
+	p := DefaultAddrPolicy()
+	p.Precedence = append(p.Precedence, PrefixValue{netip.MustParsePrefix("::ffff:127.0.0.0/104"), 100})
+	if got := p.SortAddrs(ips); !got[0].IP.Equal(v4.IP) {
+		t.Errorf("SortAddrs = %v, want 127.0.0.1 first by precedence", got)
+	}
+}
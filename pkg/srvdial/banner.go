@@ -0,0 +1,336 @@
+package srvdial
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"jeremy.visser.name/go/ssh-srv/internal/peek"
+)
+
+const (
+	// maxBanner bounds how much is peeked looking for a full banner line.
+	// RFC 4253 limits the identification line to 255 bytes.
+	maxBanner = 1024
+
+	// maxMuxSteps bounds how many gateway lines are answered per connection.
+	maxMuxSteps = 8
+
+	// slowBanner is how long before a banner is considered slow. sslh, for
+	// one, waits 2 seconds for the client to speak before picking a default.
+	slowBanner = 2 * time.Second
+)
+
+// SSHPeek checks that connections are to an SSH server, without consuming
+// the server's banner, so the connection can still be handed to ssh.
+type SSHPeek struct {
+	// Expect is what the banner line must start with, "SSH-2" if empty.
+	// Other services that speak first (SMTP, IMAP, FTP) can be checked
+	// with their own greeting.
+	Expect string
+
+	// ExpectRE, if set, is matched against the banner line (without its
+	// line ending) instead of Expect.
+	ExpectRE *regexp.Regexp
+
+	// MuxPrefix and MuxSend configure an extra handshake step for
+	// multiplexer gateways: if the server sends a line starting with
+	// MuxPrefix, it's consumed (ssh never sees it) and MuxSend is sent.
+	MuxPrefix string
+	MuxSend   string
+
+	// MinProto, if set, is the lowest protocol version accepted, like
+	// "2.0". A server announcing 1.99 speaks both 1.x and 2.0.
+	MinProto string
+
+	// RequireSoftware, if not empty, requires the software version in the
+	// banner to contain one of these, and RejectSoftware refuses banners
+	// containing any of these, such as those of known honeypots.
+	RequireSoftware []string
+	RejectSoftware  []string
+
+	// Logf, if non-nil, is given hints about unexpected banners, rather
+	// than the standard log package.
+	Logf func(format string, v ...any)
+}
+
+func (sp *SSHPeek) Peek(conn net.Conn) error {
+	p, err := newPeeker(conn)
+	if err != nil {
+		return fmt.Errorf("peekSSH: %w", err)
+	}
+	logf := sp.Logf
+	if logf == nil {
+		logf = log.Printf
+	}
+
+	start := time.Now()
+	for step := 0; ; step++ {
+		line, err := peekLine(p)
+		if err != nil {
+			return fmt.Errorf("peekSSH: %w", err)
+		}
+
+		if sp.MuxPrefix != "" && strings.HasPrefix(line, sp.MuxPrefix) && step < maxMuxSteps {
+			logf("%s: multiplexer gateway said %q, answering with %q",
+				conn.RemoteAddr(), strings.TrimSpace(line), sp.MuxSend)
+			if _, err := io.ReadFull(conn, make([]byte, len(line))); err != nil {
+				return fmt.Errorf("peekSSH: %w", err)
+			}
+			if _, err := io.WriteString(conn, sp.MuxSend); err != nil {
+				return fmt.Errorf("peekSSH: %w", err)
+			}
+			continue
+		}
+
+		if sp.ExpectRE != nil {
+			if !sp.ExpectRE.MatchString(strings.TrimRight(line, "\r\n")) {
+				return fmt.Errorf("peekSSH: wanted /%s/, got %q", sp.ExpectRE, strings.TrimSpace(line))
+			}
+			return sp.check(line)
+		}
+		wantStr := sp.Expect
+		if wantStr == "" {
+			wantStr = "SSH-2"
+		}
+		if !strings.HasPrefix(line, wantStr) {
+			if sp.Expect == "" && !strings.HasPrefix(line, "SSH-") {
+				logf("Hint: %s sent %q instead of an SSH banner; if it's a multiplexer gateway, see -mux-prefix",
+					conn.RemoteAddr(), strings.TrimSpace(line))
+			}
+			return fmt.Errorf("peekSSH: wanted '%s', got %q", wantStr, strings.TrimSpace(line))
+		}
+
+		if d := time.Since(start); d >= slowBanner {
+			logf("Hint: %s took %s to send its banner; multiplexers such as sslh wait for the client to speak first",
+				conn.RemoteAddr(), d.Round(time.Millisecond))
+		}
+		return sp.check(line)
+	}
+}
+
+// check applies MinProto, RequireSoftware and RejectSoftware to line.
+func (sp *SSHPeek) check(line string) error {
+	if sp.MinProto == "" && len(sp.RequireSoftware) == 0 && len(sp.RejectSoftware) == 0 {
+		return nil
+	}
+	b, err := parseBanner(line)
+	if err != nil {
+		return fmt.Errorf("peekSSH: %w", err)
+	}
+	if sp.MinProto != "" && compareProto(b.Proto, sp.MinProto) < 0 {
+		return fmt.Errorf("peekSSH: protocol %s is older than %s (see -min-protocol)", b.Proto, sp.MinProto)
+	}
+	if len(sp.RequireSoftware) > 0 && !slices.ContainsFunc(sp.RequireSoftware, func(s string) bool {
+		return strings.Contains(b.Software, s)
+	}) {
+		return fmt.Errorf("peekSSH: software %q isn't one of %s (see -require-software)",
+			b.Software, strings.Join(sp.RequireSoftware, ", "))
+	}
+	for _, s := range sp.RejectSoftware {
+		if strings.Contains(b.Software, s) {
+			return fmt.Errorf("peekSSH: software %q is rejected (see -reject-software)", b.Software)
+		}
+	}
+	return nil
+}
+
+// sshBanner is a server's identification line (RFC 4253 section 4.2):
+// SSH-Proto-Software Comments.
+type sshBanner struct {
+	Proto    string
+	Software string
+	Comments string
+}
+
+func parseBanner(line string) (sshBanner, error) {
+	var b sshBanner
+	line = strings.TrimRight(line, "\r\n")
+	rest, ok := strings.CutPrefix(line, "SSH-")
+	if !ok {
+		return b, fmt.Errorf("not an SSH banner: %q", line)
+	}
+	if b.Proto, rest, ok = strings.Cut(rest, "-"); !ok || b.Proto == "" {
+		return b, fmt.Errorf("no protocol version in banner %q", line)
+	}
+	b.Software, b.Comments, _ = strings.Cut(rest, " ")
+	if b.Software == "" {
+		return b, fmt.Errorf("no software version in banner %q", line)
+	}
+	return b, nil
+}
+
+// compareProto compares protocol versions like "2.0" numerically, treating
+// 1.99 (both 1.x and 2.0) as 2.0. Unparseable versions compare as 0.0.
+func compareProto(a, b string) int {
+	parse := func(v string) (int, int) {
+		if v == "1.99" {
+			return 2, 0
+		}
+		major, minor, _ := strings.Cut(v, ".")
+		x, _ := strconv.Atoi(major)
+		y, _ := strconv.Atoi(minor)
+		return x, y
+	}
+	amaj, amin := parse(a)
+	bmaj, bmin := parse(b)
+	return cmp.Or(cmp.Compare(amaj, bmaj), cmp.Compare(amin, bmin))
+}
+
+// ServerBanner returns the first line still waiting on conn, which after a
+// successful Peek is the server's banner. It's "" if conn can't be peeked.
+func ServerBanner(conn net.Conn) string {
+	p, err := newPeeker(conn)
+	if err != nil {
+		return ""
+	}
+	line, err := peekLine(p)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+// peeker reads ahead on a connection without consuming anything.
+type peeker interface {
+	// peek blocks until n bytes are available, and returns them.
+	peek(n int) ([]byte, error)
+
+	// available returns up to max bytes of whatever is waiting, blocking
+	// until there's at least one.
+	available(max int) ([]byte, error)
+}
+
+// peekable returns conn if it can be peeked at as it is, being a socket or
+// a peeker already. Otherwise it's wrapped in a peekConn, which must then
+// be used in its place.
+func peekable(conn net.Conn) net.Conn {
+	if _, err := newPeeker(conn); err == nil {
+		return conn
+	}
+	return newPeekConn(conn)
+}
+
+// newPeeker returns a peeker for conn, which must be a socket (a
+// syscall.Conn) or a peeker itself (like peekConn).
+func newPeeker(conn net.Conn) (peeker, error) {
+	switch c := conn.(type) {
+	case peeker:
+		return c, nil
+	case syscall.Conn:
+		if !peek.Supported {
+			break
+		}
+		rc, err := c.SyscallConn()
+		if err != nil {
+			return nil, err
+		}
+		return rawPeeker{rc}, nil
+	}
+	return nil, fmt.Errorf("can't peek at a %T", conn)
+}
+
+// rawPeeker peeks at a socket using MSG_PEEK. It waits in the runtime's
+// poller, so Close and deadlines interrupt it as they would a Read.
+type rawPeeker struct {
+	rc syscall.RawConn
+}
+
+// wait peeks into buf until enough reports true for the number of bytes
+// waiting, or the peer closes the connection.
+func (p rawPeeker) wait(buf []byte, enough func(n int) bool) (int, error) {
+	var n int
+	var perr error
+	err := p.rc.Read(func(fd uintptr) bool {
+		n, perr = peek.Try(int(fd), buf)
+		if perr == peek.ErrWouldBlock {
+			return false
+		}
+		return perr != nil || n == 0 || enough(n)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, perr
+}
+
+func (p rawPeeker) peek(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	n, err := p.wait(buf, func(m int) bool { return m == len(buf) })
+	if err == nil && n < len(buf) {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf[:n], err
+}
+
+func (p rawPeeker) available(max int) ([]byte, error) {
+	buf := make([]byte, max)
+	n, err := p.wait(buf, func(m int) bool { return m > 0 })
+	if err == nil && n == 0 {
+		err = io.EOF
+	}
+	return buf[:n], err
+}
+
+// peekConn is a connection that can be peeked without MSG_PEEK, by
+// buffering reads. It's used for streams that aren't plain sockets.
+type peekConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func newPeekConn(c net.Conn) *peekConn {
+	return &peekConn{Conn: c, r: bufio.NewReader(c)}
+}
+
+func (c *peekConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// CloseWrite shuts down the writing side of the connection, if supported.
+func (c *peekConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
+
+func (c *peekConn) peek(n int) ([]byte, error) {
+	return c.r.Peek(n)
+}
+
+func (c *peekConn) available(max int) ([]byte, error) {
+	if _, err := c.r.Peek(1); err != nil {
+		return nil, err
+	}
+	return c.r.Peek(min(c.r.Buffered(), max))
+}
+
+// peekLine returns the first line waiting on p, including its newline.
+func peekLine(p peeker) (string, error) {
+	data, err := p.available(maxBanner)
+	for err == nil {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			return string(data[:i+1]), nil
+		}
+		if len(data) >= maxBanner {
+			return "", fmt.Errorf("no newline in the first %d bytes", maxBanner)
+		}
+		// wait for at least one more byte:
+		if _, err = p.peek(len(data) + 1); err == nil {
+			data, err = p.available(maxBanner)
+		}
+	}
+	return "", err
+}
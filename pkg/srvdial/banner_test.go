@@ -0,0 +1,139 @@
+package srvdial
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseBanner(t *testing.T) {
+	tests := []struct {
+		line string
+		want sshBanner
+		ok   bool
+	}{
+		{"SSH-2.0-OpenSSH_9.6\r\n", sshBanner{"2.0", "OpenSSH_9.6", ""}, true},
+		{"SSH-2.0-OpenSSH_9.2p1 Debian-2+deb12u3\r\n", sshBanner{"2.0", "OpenSSH_9.2p1", "Debian-2+deb12u3"}, true},
+		{"SSH-1.99-Cisco-1.25\n", sshBanner{"1.99", "Cisco-1.25", ""}, true},
+		{"SSH-2.0-dropbear", sshBanner{"2.0", "dropbear", ""}, true},
+
+		{"220 mail.example.com ESMTP\r\n", sshBanner{}, false},
+		{"SSH-2.0\r\n", sshBanner{}, false},
+		{"SSH--OpenSSH_9.6\r\n", sshBanner{}, false},
+		{"SSH-2.0- comment\r\n", sshBanner{}, false},
+	}
+	for _, tt := range tests {
+		got, err := parseBanner(tt.line)
+		if ok := err == nil; ok != tt.ok || ok && got != tt.want {
+			t.Errorf("parseBanner(%q) = %+v, %v; want %+v, ok %v", tt.line, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCompareProto(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"2.0", "2.0", 0},
+		{"1.99", "2.0", 0},
+		{"1.5", "2.0", -1},
+		{"2.1", "2.0", 1},
+		{"2.0", "1.99", 0},
+		{"10.0", "2.0", 1},
+		{"junk", "1.0", -1},
+	}
+	for _, tt := range tests {
+		if got := compareProto(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareProto(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSSHPeekCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		sp      SSHPeek
+		line    string
+		wantErr string
+	}{
+		{"no checks", SSHPeek{}, "SSH-1.5-ancient\r\n", ""},
+		{"min protocol", SSHPeek{MinProto: "2.0"}, "SSH-1.99-OpenSSH_3.9\r\n", ""},
+		{"old protocol", SSHPeek{MinProto: "2.0"}, "SSH-1.5-ancient\r\n", "protocol 1.5 is older than 2.0"},
+		{"required", SSHPeek{RequireSoftware: []string{"OpenSSH", "dropbear"}}, "SSH-2.0-dropbear_2022.83\r\n", ""},
+		{"not required", SSHPeek{RequireSoftware: []string{"OpenSSH"}}, "SSH-2.0-dropbear_2022.83\r\n", `software "dropbear_2022.83" isn't one of OpenSSH`},
+		{"rejected", SSHPeek{RejectSoftware: []string{"Cowrie"}}, "SSH-2.0-OpenSSH_6.0p1-Cowrie\r\n", "is rejected"},
+		{"unparseable", SSHPeek{MinProto: "2.0"}, "SSH-2.0\r\n", "no protocol version"},
+	}
+	for _, tt := range tests {
+		err := tt.sp.check(tt.line)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: check(%q): %s", tt.name, tt.line, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: check(%q): %v, want an error about %q", tt.name, tt.line, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSSHPeek(t *testing.T) {
+	tests := []struct {
+		name    string
+		sp      SSHPeek
+		send    string // by the server, all at once
+		reply   string // what the client should answer, if anything
+		left    string // what's left for ssh to read
+		wantErr string
+	}{
+		{name: "banner", send: "SSH-2.0-OpenSSH_9.6\r\n", left: "SSH-2.0-OpenSSH_9.6\r\n"},
+		{name: "not SSH", send: "220 mail.example.com ESMTP\r\n", wantErr: "wanted 'SSH-2'"},
+		{name: "SMTP", sp: SSHPeek{Expect: "220 "}, send: "220 mail.example.com ESMTP\r\n", left: "220 mail.example.com ESMTP\r\n"},
+		{name: "no newline", send: strings.Repeat("x", maxBanner), wantErr: "no newline"},
+		{
+			name:  "gateway",
+			sp:    SSHPeek{MuxPrefix: "HELLO", MuxSend: "ssh\n"},
+			send:  "HELLO which service?\nSSH-2.0-OpenSSH_9.6\r\n",
+			reply: "ssh\n",
+			left:  "SSH-2.0-OpenSSH_9.6\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			go io.WriteString(server, tt.send)
+			replied := make(chan string, 1)
+			if tt.reply != "" {
+				go func() {
+					buf := make([]byte, len(tt.reply))
+					io.ReadFull(server, buf)
+					replied <- string(buf)
+				}()
+			}
+
+			tt.sp.Logf = t.Logf
+			pc := newPeekConn(client)
+			err := tt.sp.Peek(pc)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("Peek: %s", err)
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Peek: %v, want an error about %q", err, tt.wantErr)
+				}
+				return
+			}
+			if tt.reply != "" {
+				if got := <-replied; got != tt.reply {
+					t.Errorf("client answered %q, want %q", got, tt.reply)
+				}
+			}
+			buf := make([]byte, len(tt.left))
+			if _, err := io.ReadFull(pc, buf); err != nil || string(buf) != tt.left {
+				t.Errorf("left %q, %v; want %q", buf, err, tt.left)
+			}
+		})
+	}
+}
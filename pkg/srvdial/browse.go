@@ -0,0 +1,114 @@
+package srvdial
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// An Instance is a DNS-SD service instance (RFC 6763), as listed by Browse.
+type Instance struct {
+	Name     string     // its full name, like "myhost._ssh._tcp.example.com."
+	Instance string     // its own part of that, like "myhost"
+	Targets  []*net.SRV // from its SRV records
+	TXT      []string   // from its TXT record, usually key=value pairs
+	Err      error      // if its SRV records couldn't be looked up
+}
+
+// Browse lists the DNS-SD service instances advertised under domain, such
+// as each sshd of a fleet: the instances named by the PTR records at
+// _service._proto.domain, with their own SRV and TXT records, sorted by
+// name. Under .local, they're looked up with multicast DNS, from every
+// responder on the link.
+func (sd *SRVDialer) Browse(ctx context.Context, service, proto, domain string) (_ []Instance, err error) {
+	browse := "_" + service + "._" + proto + "." + normalizeTarget(domain)
+	ctx, span := sd.startSpan(ctx, "browse", attrName.String(browse))
+	defer func() { endSpan(ctx, span, err) }()
+
+	lctx, cancel := sd.lookupContext(ctx)
+	ptrs, err := lookupRecords(lctx, true, browse, dnsmessage.TypePTR)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attrRecords.Int(len(ptrs)))
+
+	instances := make([]Instance, len(ptrs))
+	var wg sync.WaitGroup
+	for i, rr := range ptrs {
+		inst := &instances[i]
+		inst.Name = rr.Body.(*dnsmessage.PTRResource).PTR.String()
+		inst.Instance = strings.TrimSuffix(inst.Name, ".")
+		if n := len(inst.Instance) - len(browse) - 1; n > 0 && strings.EqualFold(inst.Instance[n:], "."+browse) {
+			inst.Instance = inst.Instance[:n]
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lctx, cancel := sd.lookupContext(ctx)
+			defer cancel()
+			srvs, err := lookupRecords(lctx, false, inst.Name, dnsmessage.TypeSRV)
+			if err != nil {
+				inst.Err = err
+				return
+			}
+			for _, rr := range srvs {
+				srv := rr.Body.(*dnsmessage.SRVResource)
+				inst.Targets = append(inst.Targets, &net.SRV{Target: srv.Target.String(), Port: srv.Port, Priority: srv.Priority, Weight: srv.Weight})
+			}
+			// a missing TXT record is no great loss:
+			if txts, err := lookupRecords(lctx, false, inst.Name, dnsmessage.TypeTXT); err == nil {
+				for _, rr := range txts {
+					inst.TXT = append(inst.TXT, rr.Body.(*dnsmessage.TXTResource).TXT...)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	slices.SortFunc(instances, func(a, b Instance) int {
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+	return instances, nil
+}
+
+// lookupRecords returns name's records of qtype: with mDNS for names under
+// .local (from every responder, with all), and otherwise from the system
+// nameservers. A name without any is a not-found *net.DNSError, as from
+// the net package.
+func lookupRecords(ctx context.Context, all bool, name string, qtype dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	name = strings.TrimSuffix(name, ".")
+	if IsLocal(name) {
+		Stats.DNSLookups.Add(1)
+		return mdnsQuery(ctx, all, []string{name}, qtype)
+	}
+
+	m, err := lookupRaw(ctx, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	notFound := &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	switch m.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, notFound
+	default:
+		return nil, fmt.Errorf("lookup %s %s: %s", name, dnsType(qtype), dnsRCode(m.RCode))
+	}
+	var rrs []dnsmessage.Resource
+	for _, rr := range m.Answers {
+		if rr.Header.Type == qtype {
+			rrs = append(rrs, rr)
+		}
+	}
+	if len(rrs) == 0 {
+		notFound.Err = "no " + dnsType(qtype) + " records"
+		return nil, notFound
+	}
+	return rrs, nil
+}
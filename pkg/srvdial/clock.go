@@ -0,0 +1,44 @@
+package srvdial
+
+import "time"
+
+// Clock is what the racing, retries and timeouts of an SRVDialer are timed
+// with, so that a fake one can step through them deterministically in
+// tests. The zero SRVDialer uses the real time.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a time.Timer from a Clock. C is nil for timers from AfterFunc.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// realClock is the Clock of the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+func (t realTimer) Stop() bool          { return t.t.Stop() }
+
+// clock returns Clock, or the real one if it's not set.
+func (sd *SRVDialer) clock() Clock {
+	if sd.Clock == nil {
+		return realClock{}
+	}
+	return sd.Clock
+}
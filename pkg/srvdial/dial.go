@@ -0,0 +1,149 @@
+package srvdial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// An Option configures Dial.
+type Option func(*dialOptions)
+
+type dialOptions struct {
+	sd       SRVDialer
+	service  string
+	proto    string
+	timeout  time.Duration
+	peek     bool // Peek was set, maybe to nil
+	fallback string
+}
+
+// WithService looks up _service._proto SRV records, rather than _ssh._tcp.
+func WithService(service, proto string) Option {
+	return func(o *dialOptions) {
+		o.service, o.proto = service, proto
+	}
+}
+
+// WithTimeout gives up after d, rather than DefaultTimeout, or whenever the
+// context given to Dial is done.
+func WithTimeout(d time.Duration) Option {
+	return func(o *dialOptions) {
+		o.timeout = d
+	}
+}
+
+// WithRaceInterval waits d before trying the next target, rather than
+// DefaultStagger (see SRVDialer.Stagger).
+func WithRaceInterval(d time.Duration) Option {
+	return func(o *dialOptions) {
+		o.sd.Stagger = d
+	}
+}
+
+// WithPeek checks each connection with peek before it's used, rather than
+// with SSHPeek (for ssh) or not at all (for other services). A nil peek
+// uses the first connection made.
+func WithPeek(peek func(net.Conn) error) Option {
+	return func(o *dialOptions) {
+		o.sd.Peek, o.peek = peek, true
+	}
+}
+
+// WithFallback connects to the name itself on port if it has no SRV
+// records, rather than failing with ErrSRVLookup.
+func WithFallback(port string) Option {
+	return func(o *dialOptions) {
+		o.fallback = port
+	}
+}
+
+// WithDialer makes connections with d, rather than a net.Dialer. d is given
+// the names of targets rather than their addresses, for dialers (such as
+// proxies) that resolve names themselves, so WithResolver only applies to
+// SRV lookups then.
+func WithDialer(d ContextDialer) Option {
+	return func(o *dialOptions) {
+		o.sd.Dialer, o.sd.PreResolve = d, false
+	}
+}
+
+// WithResolver looks up SRV records, and the addresses of targets and the
+// fallback, with r rather than net.DefaultResolver.
+func WithResolver(r Resolver) Option {
+	return func(o *dialOptions) {
+		o.sd.Resolver = r
+	}
+}
+
+// WithLogger sends progress messages to l at slog.LevelInfo, rather than to
+// the standard log package. See WithLogf to silence them.
+func WithLogger(l *slog.Logger) Option {
+	return WithLogf(func(format string, v ...any) {
+		if l.Enabled(context.Background(), slog.LevelInfo) {
+			l.Info(fmt.Sprintf(format, v...))
+		}
+	})
+}
+
+// WithLogf sends progress messages to logf, which may discard them, rather
+// than to the standard log package (see SRVDialer.Logf).
+func WithLogf(logf func(format string, v ...any)) Option {
+	return func(o *dialOptions) {
+		o.sd.Logf = logf
+	}
+}
+
+// WithTracer records spans of the lookups and connection attempts with t
+// (see SRVDialer.Tracer).
+func WithTracer(t trace.Tracer) Option {
+	return func(o *dialOptions) {
+		o.sd.Tracer = t
+	}
+}
+
+// Dial connects to name by its SRV records, like SRVDialer.DialSRV, with
+// _ssh._tcp records checked by SSHPeek unless opts say otherwise. It gives
+// up when ctx is done, and its errors are those of DialSRV.
+func Dial(ctx context.Context, name string, opts ...Option) (net.Conn, error) {
+	o := &dialOptions{
+		// resolving targets ahead of time also takes WithResolver to them:
+		sd:      SRVDialer{PreResolve: true},
+		service: "ssh",
+		proto:   "tcp",
+		timeout: DefaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if !o.peek && o.service == "ssh" {
+		o.sd.Peek = (&SSHPeek{Logf: o.sd.Logf}).Peek
+	}
+	ctx, cancel := context.WithTimeoutCause(ctx, o.timeout, ErrTimeout)
+	defer cancel()
+
+	sc, err := o.sd.DialSRVContext(ctx, o.service, o.proto, name)
+	if err == nil || o.fallback == "" || !errors.Is(err, ErrSRVLookup) {
+		return sc.Conn, err
+	}
+	hostPort := net.JoinHostPort(name, o.fallback)
+	o.sd.logf("Fallback to non-SRV: %s", hostPort)
+	var conn net.Conn
+	if !o.sd.PreResolve {
+		conn, err = o.sd.tryFallback(ctx, hostPort)
+	} else {
+		var ips []net.IPAddr
+		if ips, err = o.sd.LookupAhead(ctx, name)(); err == nil {
+			conn, err = o.sd.DialFallback(ctx, ips, o.fallback)
+		}
+	}
+	if err != nil {
+		return nil, timedOut(ctx, err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,283 @@
+package srvdial
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// resolvConf is where the system nameservers are listed.
+const resolvConf = "/etc/resolv.conf"
+
+// rawTimeout bounds each query lookupRaw sends, and rawAttempts is how many
+// times it goes through the nameservers, as with timeout and attempts in
+// resolv.conf, so that a lost reply costs a retry rather than the lot.
+const (
+	rawTimeout  = 2 * time.Second
+	rawAttempts = 2
+)
+
+// A RawResolver is a Resolver that can also answer queries of any type,
+// for the records (SOA, say) and header bits that Resolver doesn't cover.
+// The AD bit on its answers is only believed if it's also a
+// ValidatingResolver that says so.
+type RawResolver interface {
+	Resolver
+	LookupRaw(ctx context.Context, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error)
+}
+
+// A ValidatingResolver is a RawResolver that can say whether the AD bit on
+// its answers can be believed: because it validates DNSSEC itself, or has
+// its answers from a validating resolver it trusts. One that passes queries
+// on to another Resolver should say what ValidatesDNSSEC does of that one.
+type ValidatingResolver interface {
+	RawResolver
+	ValidatesDNSSEC() bool
+}
+
+// ValidatesDNSSEC reports whether the AD bit on answers from r can be
+// believed. For a ValidatingResolver, that's what it says; for nil or a
+// *net.Resolver, asking the system nameservers, it's whether trustAD does.
+// Other resolvers are not believed.
+func ValidatesDNSSEC(r Resolver) bool {
+	switch r := r.(type) {
+	case nil, *net.Resolver:
+		return trustAD()
+	case ValidatingResolver:
+		return r.ValidatesDNSSEC()
+	}
+	return false
+}
+
+// A TXTResolver is a Resolver that can also look up TXT records, as
+// *net.Resolver can.
+type TXTResolver interface {
+	Resolver
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// LookupTXT looks up name's TXT records with r: with its LookupTXT, if it's
+// a TXTResolver (or nil, for net.DefaultResolver), or else with LookupRaw.
+// Each record's strings are joined into one, as *net.Resolver does.
+func LookupTXT(ctx context.Context, r Resolver, name string) ([]string, error) {
+	switch r := r.(type) {
+	case nil:
+		return net.DefaultResolver.LookupTXT(ctx, name)
+	case TXTResolver:
+		return r.LookupTXT(ctx, name)
+	}
+	m, err := LookupRaw(ctx, r, name, dnsmessage.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	notFound := &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	switch m.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, notFound
+	default:
+		return nil, fmt.Errorf("lookup %s TXT: %s", name, dnsRCode(m.RCode))
+	}
+	var txts []string
+	for _, rr := range m.Answers {
+		if txt, ok := rr.Body.(*dnsmessage.TXTResource); ok {
+			txts = append(txts, strings.Join(txt.TXT, ""))
+		}
+	}
+	if len(txts) == 0 {
+		notFound.Err = "no TXT records"
+		return nil, notFound
+	}
+	return txts, nil
+}
+
+// errNoRawLookup is returned by LookupRaw for resolvers it can't ask.
+var errNoRawLookup = errors.New("the resolver can't be asked for other record types")
+
+// LookupRaw looks up name and qtype with r: with its LookupRaw, if it's a
+// RawResolver, or from the system nameservers, if it's nil or a
+// *net.Resolver (like net.DefaultResolver). Other resolvers can't be asked,
+// as going around them to the system nameservers could leak names they
+// keep private, or give different answers.
+func LookupRaw(ctx context.Context, r Resolver, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	switch r := r.(type) {
+	case nil, *net.Resolver:
+		return lookupRaw(ctx, name, qtype)
+	case RawResolver:
+		return r.LookupRaw(ctx, name, qtype)
+	}
+	return nil, fmt.Errorf("lookup %s %s: %w", name, dnsType(qtype), errNoRawLookup)
+}
+
+// nameservers returns the nameservers from resolvConf as host:port, in
+// order, falling back to localhost like the system resolver does.
+func nameservers() []string {
+	var servers []string
+	if b, err := os.ReadFile(resolvConf); err == nil {
+		for _, line := range strings.Split(string(b), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				servers = append(servers, net.JoinHostPort(fields[1], "53"))
+			}
+		}
+	}
+	if len(servers) == 0 {
+		servers = []string{"127.0.0.1:53", "[::1]:53"}
+	}
+	return servers
+}
+
+// trustAD reports whether the AD (authenticated data) bit from the system
+// nameservers can be believed, which is when resolvConf says so with
+// "options trust-ad" (as for glibc), or the nameservers are all on this
+// host, so nobody on the path could have set it.
+func trustAD() bool {
+	if b, err := os.ReadFile(resolvConf); err == nil {
+		for _, line := range strings.Split(string(b), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && fields[0] == "options" && slices.Contains(fields[1:], "trust-ad") {
+				return true
+			}
+		}
+	}
+	for _, server := range nameservers() {
+		host, _, _ := net.SplitHostPort(server)
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupValidated looks up name and qtype with r like LookupRaw, and
+// reports whether the answer is DNSSEC validated, going by the AD bit, if
+// that can be trusted (see ValidatesDNSSEC).
+func lookupValidated(ctx context.Context, r Resolver, name string, qtype dnsmessage.Type) (*dnsmessage.Message, bool, error) {
+	if !ValidatesDNSSEC(r) {
+		return nil, false, errors.New("not trusting the AD bit from a remote nameserver (see trust-ad in resolv.conf)")
+	}
+	m, err := LookupRaw(ctx, r, name, qtype)
+	if err != nil {
+		return nil, false, err
+	}
+	return m, m.RCode == dnsmessage.RCodeSuccess && m.AuthenticData, nil
+}
+
+// lookupRaw queries the system nameservers for name and qtype, for the
+// record types (and header bits) the net package doesn't expose. Each query
+// gets rawTimeout, going on to the next nameserver (and round again, up to
+// rawAttempts times) if there's no answer by then. The first response is
+// returned whatever its RCode, so callers can tell NXDOMAIN from SERVFAIL.
+func lookupRaw(ctx context.Context, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(1232, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	q := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               uint16(rand.N(1 << 16)),
+			RecursionDesired: true,
+			AuthenticData:    true, // ask for the AD bit (RFC 6840)
+		},
+		Questions:   []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}},
+		Additionals: []dnsmessage.Resource{{Header: opt, Body: &dnsmessage.OPTResource{}}},
+	}
+	query, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	Stats.DNSLookups.Add(1)
+	servers := nameservers()
+	var errs []error
+	for try := range rawAttempts * len(servers) {
+		server := servers[try%len(servers)]
+		m, err := exchangeTimeout(ctx, "udp", server, query)
+		if err == nil && m.Truncated {
+			m, err = exchangeTimeout(ctx, "tcp", server, query)
+		}
+		if err == nil {
+			return m, nil
+		}
+		if ctx.Err() != nil {
+			// out of time overall, rather than for this query:
+			return nil, fmt.Errorf("lookup %s: %w", name, context.Cause(ctx))
+		}
+		if try < len(servers) {
+			// the same again would add nothing on the next round
+			errs = append(errs, err)
+		}
+	}
+	return nil, fmt.Errorf("lookup %s: %w", name, errors.Join(errs...))
+}
+
+// exchangeTimeout is exchange, giving up after rawTimeout.
+func exchangeTimeout(ctx context.Context, network, server string, query []byte) (*dnsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, rawTimeout)
+	defer cancel()
+	return exchange(ctx, network, server, query)
+}
+
+// exchange sends query to server and returns the matching response.
+func exchange(ctx context.Context, network, server string, query []byte) (*dnsmessage.Message, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	if dnsLogf != nil {
+		c = traceDNSConn(c, network, server)
+	}
+	defer c.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+
+	buf := make([]byte, 65535)
+	var n int
+	if network == "tcp" {
+		if _, err := c.Write(binary.BigEndian.AppendUint16(nil, uint16(len(query)))); err != nil {
+			return nil, err
+		}
+		if _, err := c.Write(query); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(c, buf[:2]); err != nil {
+			return nil, err
+		}
+		n = int(binary.BigEndian.Uint16(buf))
+		if _, err := io.ReadFull(c, buf[:n]); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := c.Write(query); err != nil {
+			return nil, err
+		}
+		if n, err = c.Read(buf); err != nil {
+			return nil, err
+		}
+	}
+
+	var m dnsmessage.Message
+	if err := m.Unpack(buf[:n]); err != nil {
+		return nil, fmt.Errorf("%s: %w", server, err)
+	}
+	if m.ID != binary.BigEndian.Uint16(query) {
+		return nil, fmt.Errorf("%s: response ID mismatch", server)
+	}
+	return &m, nil
+}
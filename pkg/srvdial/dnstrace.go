@@ -0,0 +1,205 @@
+package srvdial
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsLogf, if set by TraceDNS, is given each DNS query and response.
+var dnsLogf func(format string, v ...any)
+
+// TraceDNS logs every DNS query made in the process, with its response
+// (or lack of one), to logf: those of net.DefaultResolver, which is made to
+// use Go's own resolver so that they can be seen, and the raw queries for
+// the record types the net package doesn't do. It's for debugging, and must
+// be called before any lookups are made.
+func TraceDNS(logf func(format string, v ...any)) {
+	dnsLogf = logf
+	net.DefaultResolver.PreferGo = true
+	net.DefaultResolver.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		var d net.Dialer
+		c, err := d.DialContext(ctx, network, address)
+		if err != nil {
+			logf("DNS @%s/%s: %s", address, network, err)
+			return nil, err
+		}
+		return traceDNSConn(c, network, address), nil
+	}
+}
+
+// traceDNSConn returns c, which talks DNS to server, logging each query
+// and response through it to dnsLogf. Datagram connections stay
+// net.PacketConns, which the resolver relies on to tell them apart.
+func traceDNSConn(c net.Conn, network, server string) net.Conn {
+	tc := &dnsTraceConn{
+		Conn:    c,
+		stream:  !strings.HasPrefix(network, "udp"),
+		server:  server + "/" + network,
+		pending: map[uint16]dnsQuery{},
+	}
+	if _, ok := c.(net.PacketConn); ok {
+		return dnsTracePacketConn{tc}
+	}
+	return tc
+}
+
+type dnsQuery struct {
+	q    dnsmessage.Question
+	sent time.Time
+}
+
+type dnsTraceConn struct {
+	net.Conn
+	stream bool   // TCP, with each message preceded by its length
+	server string // for logging
+
+	mu      sync.Mutex
+	pending map[uint16]dnsQuery // by message ID
+	wbuf    []byte              // of a stream, not yet a whole message
+	rbuf    []byte
+	err     error // from the last read, if it failed
+}
+
+func (c *dnsTraceConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.sent(b[:n])
+	return n, err
+}
+
+func (c *dnsTraceConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.received(b[:n], err)
+	return n, err
+}
+
+// Close logs the queries nothing came back for.
+func (c *dnsTraceConn) Close() error {
+	c.mu.Lock()
+	why := ""
+	if c.err != nil {
+		why = ": " + c.err.Error()
+	}
+	for _, q := range c.pending {
+		dnsLogf("DNS %s %s @%s: no response after %s%s", q.q.Name, dnsType(q.q.Type), c.server, time.Since(q.sent).Round(10*time.Microsecond), why)
+	}
+	clear(c.pending)
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
+
+func (c *dnsTraceConn) sent(b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, msg := range c.messages(&c.wbuf, b) {
+		var p dnsmessage.Parser
+		h, err := p.Start(msg)
+		if err != nil {
+			continue
+		}
+		if q, err := p.Question(); err == nil {
+			c.pending[h.ID] = dnsQuery{q, time.Now()}
+		}
+	}
+}
+
+func (c *dnsTraceConn) received(b []byte, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.err = err
+	}
+	for _, msg := range c.messages(&c.rbuf, b) {
+		var m dnsmessage.Message
+		if err := m.Unpack(msg); err != nil {
+			dnsLogf("DNS @%s: unparseable response: %s", c.server, err)
+			continue
+		}
+		q, ok := c.pending[m.ID]
+		if !ok {
+			// not ours, or a duplicate; the resolver ignores those too
+			continue
+		}
+		delete(c.pending, m.ID)
+		var flags string
+		if m.Truncated {
+			flags += ", truncated"
+		}
+		if m.AuthenticData {
+			flags += ", AD"
+		}
+		answers := fmt.Sprintf("%d answers", len(m.Answers))
+		if len(m.Answers) == 1 {
+			answers = "1 answer"
+		}
+		dnsLogf("DNS %s %s @%s: %s, %s%s, %s", q.q.Name, dnsType(q.q.Type), c.server,
+			dnsRCode(m.RCode), answers, flags, time.Since(q.sent).Round(10*time.Microsecond))
+	}
+}
+
+// messages returns the whole DNS messages in b, each one if a datagram, or
+// otherwise those completed in buf by adding b to it.
+func (c *dnsTraceConn) messages(buf *[]byte, b []byte) [][]byte {
+	if !c.stream {
+		if len(b) == 0 {
+			return nil
+		}
+		return [][]byte{b}
+	}
+	*buf = append(*buf, b...)
+	var msgs [][]byte
+	for len(*buf) >= 2 {
+		n := int(binary.BigEndian.Uint16(*buf))
+		if len(*buf) < 2+n {
+			break
+		}
+		msgs = append(msgs, (*buf)[2:2+n])
+		*buf = (*buf)[2+n:]
+	}
+	return msgs
+}
+
+// dnsTracePacketConn is a dnsTraceConn over a net.PacketConn.
+type dnsTracePacketConn struct {
+	*dnsTraceConn
+}
+
+func (c dnsTracePacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.Conn.(net.PacketConn).ReadFrom(b)
+	c.received(b[:n], err)
+	return n, addr, err
+}
+
+func (c dnsTracePacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.Conn.(net.PacketConn).WriteTo(b, addr)
+	c.sent(b[:n])
+	return n, err
+}
+
+// dnsType names a query type as in zone files, like "SRV".
+func dnsType(t dnsmessage.Type) string {
+	return strings.TrimPrefix(t.String(), "Type")
+}
+
+var dnsRCodes = map[dnsmessage.RCode]string{
+	dnsmessage.RCodeSuccess:        "NOERROR",
+	dnsmessage.RCodeFormatError:    "FORMERR",
+	dnsmessage.RCodeServerFailure:  "SERVFAIL",
+	dnsmessage.RCodeNameError:      "NXDOMAIN",
+	dnsmessage.RCodeNotImplemented: "NOTIMP",
+	dnsmessage.RCodeRefused:        "REFUSED",
+}
+
+// dnsRCode names a response code as dig does, like "NXDOMAIN".
+func dnsRCode(rc dnsmessage.RCode) string {
+	if name, ok := dnsRCodes[rc]; ok {
+		return name
+	}
+	return rc.String()
+}
@@ -0,0 +1,46 @@
+package srvdial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Errors from DialSRV and friends, for callers to tell apart with
+// errors.Is. They're wrapped around the underlying errors, which are kept.
+var (
+	// ErrSRVLookup is wrapped by the error from DialSRV when there were no
+	// SRV records to try, either because there are none (ErrNoSRVRecords)
+	// or because the lookup failed (see ServFail), for falling back to the
+	// name itself.
+	ErrSRVLookup = errors.New("LookupSRV")
+
+	// ErrNoSRVRecords is wrapped, along with ErrSRVLookup, when the name
+	// doesn't exist or has no SRV records (NXDOMAIN or NODATA).
+	ErrNoSRVRecords = errors.New("no SRV records")
+
+	// ErrAllTargetsFailed is wrapped by the error from DialSRV when SRV
+	// targets were tried, but none could be used. The error from each
+	// target is wrapped along with it.
+	ErrAllTargetsFailed = errors.New("all SRV targets failed")
+
+	// ErrPeekMismatch is wrapped by the error from a target that was
+	// connected to, but failed Peek: it sent the wrong banner, or none.
+	ErrPeekMismatch = errors.New("peek")
+
+	// ErrTimeout is wrapped by the error from DialSRV and Dial when the
+	// deadline passed before anything was connected to.
+	ErrTimeout = errors.New("timed out")
+
+	// ErrHandoff is wrapped by the error from Handoff and HandoffTo, when
+	// a connection was made but couldn't be passed on.
+	ErrHandoff = errors.New("failed handing socket")
+)
+
+// timedOut wraps err in ErrTimeout if it's because ctx's deadline passed.
+func timedOut(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && !errors.Is(err, ErrTimeout) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+package srvdial
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// LookupAhead starts resolving host's addresses while the SRV query is
+// still in flight, so that falling back to non-SRV doesn't cost a second
+// round trip to the resolver. The returned func waits for the answer.
+func (sd *SRVDialer) LookupAhead(ctx context.Context, host string) func() ([]net.IPAddr, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return func() ([]net.IPAddr, error) {
+			return []net.IPAddr{{IP: ip.AsSlice(), Zone: ip.Zone()}}, nil
+		}
+	}
+
+	var ips []net.IPAddr
+	var err error
+	done := make(chan struct{})
+	Stats.DNSLookups.Add(1)
+	go func() {
+		defer close(done)
+		ctx, span := sd.startSpan(ctx, "addr resolve", attrName.String(host))
+		lctx, cancel := sd.lookupContext(ctx)
+		defer cancel()
+		ips, err = sd.resolver().LookupIPAddr(lctx, host)
+		span.SetAttributes(attrAddrs.Int(len(ips)))
+		endSpan(ctx, span, err)
+	}()
+	return func() ([]net.IPAddr, error) {
+		<-done
+		return ips, err
+	}
+}
+
+// DialFallback races connections to ips on port like DialSRV does for SRV
+// targets, alternating between IPv6 and IPv4 (as in RFC 8305), so that a
+// broken address family doesn't hold everything up. With AddrPolicy, they're
+// ordered by RFC 6724 first.
+func (sd *SRVDialer) DialFallback(ctx context.Context, ips []net.IPAddr, port string) (net.Conn, error) {
+	if len(ips) == 0 {
+		return nil, errors.New("no addresses to fall back to")
+	}
+	return sd.DialFallbacks(ctx, []Fallback{{Port: port, IPs: ips}})
+}
+
+// A Fallback is a host to connect to without SRV. IPs are its addresses,
+// as from LookupAhead. Without them, Host is dialled by name, leaving it
+// to the Dialer (a proxy, say) to resolve.
+type Fallback struct {
+	Host string
+	Port string
+	IPs  []net.IPAddr
+}
+
+// DialFallbacks is DialFallback for several hosts, each on its own port,
+// such as the old and new names of a bastion mid-migration. Their
+// addresses are taken in turn, the first host's first, so that one
+// host's dead addresses don't hold up the next.
+func (sd *SRVDialer) DialFallbacks(ctx context.Context, fallbacks []Fallback) (net.Conn, error) {
+	var hosts [][]string
+	var errs []error
+	for _, fb := range fallbacks {
+		if ips, ok := sd.ResolveOverride(fb.Host, fb.Port); ok {
+			sd.logf("Using %s for %s (see -resolve)", joinIPs(ips), net.JoinHostPort(fb.Host, fb.Port))
+			fb.IPs = ips
+		}
+		var addrs []string
+		if len(fb.IPs) == 0 {
+			addrs = append(addrs, net.JoinHostPort(fb.Host, fb.Port))
+		}
+		ips, ipErrs := sd.orderAddrs(fb.IPs)
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip.String(), fb.Port))
+		}
+		errs = append(errs, ipErrs...)
+		hosts = append(hosts, addrs)
+	}
+
+	var tryAddr []func(context.Context) (net.Conn, error)
+	for _, addr := range takeTurns(hosts) {
+		tryAddr = append(tryAddr, func(ctx context.Context) (net.Conn, error) {
+			done := sd.track(addr)
+			conn, err := sd.tryFallback(ctx, addr)
+			done(err)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", addr, err)
+			}
+			return conn, nil
+		})
+	}
+	if len(tryAddr) == 0 {
+		return nil, cmp.Or(errors.Join(errs...), errors.New("no hosts to fall back to"))
+	}
+	for _, err := range errs {
+		sd.logf("%s", err)
+	}
+
+	return race[net.Conn](ctx, sd.clock(), tryAddr, sd.stagger(nil), sd.MaxParallel)
+}
+
+// tryFallback connects to one fallback address and peeks at it.
+func (sd *SRVDialer) tryFallback(ctx context.Context, addr string) (conn net.Conn, err error) {
+	ctx, span := sd.startSpan(ctx, "dial target", attrTarget.String(addr))
+	defer func() { endSpan(ctx, span, err) }()
+
+	sd.logf("Trying to connect: %s", addr)
+	if conn, err = sd.DialContext(ctx, "tcp", addr); err != nil {
+		return nil, err
+	}
+	stop := closeWhenDone(ctx, conn)
+	if conn, err = sd.tryPeek(ctx, conn); err != nil {
+		return nil, err
+	}
+	if !stop() {
+		return nil, ctx.Err()
+	}
+	return conn, nil
+}
+
+// takeTurns flattens lists, taking the first of each in turn, then the
+// second of each, and so on.
+func takeTurns[T any](lists [][]T) []T {
+	var out []T
+	for i := 0; ; i++ {
+		more := false
+		for _, l := range lists {
+			if i < len(l) {
+				out = append(out, l[i])
+				more = true
+			}
+		}
+		if !more {
+			return out
+		}
+	}
+}
+
+// interleaveFamilies reorders ips to alternate between IPv6 and IPv4,
+// starting with whichever family comes first, and otherwise keeping the
+// resolver's order.
+func interleaveFamilies(ips []net.IPAddr) []net.IPAddr {
+	var first, second []net.IPAddr
+	for _, ip := range ips {
+		if len(first) == 0 || (ip.IP.To4() == nil) == (first[0].IP.To4() == nil) {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+
+	out := make([]net.IPAddr, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			out = append(out, first[i])
+		}
+		if i < len(second) {
+			out = append(out, second[i])
+		}
+	}
+	return out
+}
@@ -0,0 +1,82 @@
+package srvdial
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"jeremy.visser.name/go/ssh-srv/internal/fdpass"
+)
+
+// ConnFD calls f with conn's file descriptor. The descriptor stays owned by
+// conn: f must neither close it nor keep it after returning. Unlike File,
+// this doesn't dup the descriptor, or put the socket in blocking mode.
+func ConnFD(conn net.Conn, f func(fd int) error) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("%T has no file descriptor", conn)
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ferr error
+	if err := rc.Control(func(fd uintptr) { ferr = f(int(fd)) }); err != nil {
+		return err
+	}
+	return ferr
+}
+
+// Handoff passes conn's file descriptor over the Unix socket sock, as ssh's
+// ProxyUseFdPass expects, giving up after timeout. conn should be closed
+// afterwards, as the receiver has a copy of its own. Errors wrap ErrHandoff.
+func Handoff(conn net.Conn, sock int, timeout time.Duration) error {
+	err := passFD(conn, func(fd int) error {
+		return fdpass.Send(sock, fd, timeout)
+	})
+	if err != nil {
+		return fmt.Errorf("%w to fd %d: Sendmsg: %w", ErrHandoff, sock, err)
+	}
+	return nil
+}
+
+// HandoffTo connects to the Unix socket at path and passes conn's file
+// descriptor over it, like Handoff, for brokers and tools other than ssh.
+func HandoffTo(conn net.Conn, path string, timeout time.Duration) error {
+	if err := handoffTo(conn, path, timeout); err != nil {
+		return fmt.Errorf("%w to %s: %w", ErrHandoff, path, err)
+	}
+	return nil
+}
+
+func handoffTo(conn net.Conn, path string, timeout time.Duration) error {
+	c, err := net.DialTimeout("unix", path, timeout)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	uc := c.(*net.UnixConn)
+	if err := uc.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	return passFD(conn, func(fd int) error {
+		if err := fdpass.SendConn(uc, fd); err != nil {
+			return fmt.Errorf("Sendmsg: %w", err)
+		}
+		return nil
+	})
+}
+
+// passFD calls send to pass on conn's file descriptor, first putting the
+// socket in blocking mode, which is what receivers like ssh expect of a
+// descriptor they're given. conn can't be used after that.
+func passFD(conn net.Conn, send func(fd int) error) error {
+	return ConnFD(conn, func(fd int) error {
+		if err := fdpass.SetBlocking(fd, true); err != nil {
+			return err
+		}
+		return send(fd)
+	})
+}
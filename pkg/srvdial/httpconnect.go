@@ -0,0 +1,80 @@
+package srvdial
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// maxConnectResponse bounds the size of a CONNECT response header.
+const maxConnectResponse = 16 << 10
+
+// httpConnectDialer connects through an HTTP proxy using the CONNECT
+// method, with optional basic authentication and extra request headers.
+type httpConnectDialer struct {
+	proxy   string
+	user    *url.Userinfo
+	header  []string // "Name: value"
+	forward ContextDialer
+}
+
+func (d *httpConnectDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	c, err := d.forward.DialContext(ctx, "tcp", d.proxy)
+	if err != nil {
+		return nil, err
+	}
+	restore := handshakeDeadline(ctx, c)
+	defer restore()
+
+	if err := d.connect(c, address); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("http proxy %s: CONNECT %s: %w", d.proxy, address, err)
+	}
+	return c, nil
+}
+
+func (d *httpConnectDialer) connect(c net.Conn, address string) error {
+	var req strings.Builder
+	fmt.Fprintf(&req, "CONNECT %s HTTP/1.1\r\nHost: %[1]s\r\n", address)
+	if d.user != nil {
+		pass, _ := d.user.Password()
+		cred := base64.StdEncoding.EncodeToString([]byte(d.user.Username() + ":" + pass))
+		fmt.Fprintf(&req, "Proxy-Authorization: Basic %s\r\n", cred)
+	}
+	for _, h := range d.header {
+		fmt.Fprintf(&req, "%s\r\n", h)
+	}
+	req.WriteString("\r\n")
+	if _, err := c.Write([]byte(req.String())); err != nil {
+		return err
+	}
+
+	// Read a byte at a time, so nothing the server sends after the header
+	// (such as its SSH banner) ends up in our buffer instead of the socket.
+	var resp []byte
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(resp, []byte("\r\n\r\n")) {
+		if len(resp) >= maxConnectResponse {
+			return errors.New("response header too long")
+		}
+		if _, err := c.Read(b); err != nil {
+			return err
+		}
+		resp = append(resp, b[0])
+	}
+
+	status, _, _ := strings.Cut(string(resp), "\r\n")
+	proto, code, ok := strings.Cut(status, " ")
+	if !ok || !strings.HasPrefix(proto, "HTTP/1.") {
+		return fmt.Errorf("malformed response %q", status)
+	}
+	if !strings.HasPrefix(code, "200") {
+		return fmt.Errorf("proxy said %q", code)
+	}
+	return nil
+}
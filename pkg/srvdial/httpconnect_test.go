@@ -0,0 +1,80 @@
+package srvdial
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHTTPConnect(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    *url.Userinfo
+		header  []string
+		req     string
+		resp    string
+		wantErr string
+	}{
+		{
+			name: "ok",
+			req:  "CONNECT host.example.com:22 HTTP/1.1\r\nHost: host.example.com:22\r\n\r\n",
+			resp: "HTTP/1.1 200 Connection established\r\n\r\n",
+		},
+		{
+			name:   "auth and headers",
+			user:   url.UserPassword("user", "secret"),
+			header: []string{"X-Tenant: a", "X-Trace: b"},
+			req: "CONNECT host.example.com:22 HTTP/1.1\r\nHost: host.example.com:22\r\n" +
+				"Proxy-Authorization: Basic dXNlcjpzZWNyZXQ=\r\nX-Tenant: a\r\nX-Trace: b\r\n\r\n",
+			resp: "HTTP/1.0 200 OK\r\nVia: proxy\r\n\r\n",
+		},
+		{
+			name:    "denied",
+			req:     "CONNECT host.example.com:22 HTTP/1.1\r\nHost: host.example.com:22\r\n\r\n",
+			resp:    "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n",
+			wantErr: `proxy said "407 Proxy Authentication Required"`,
+		},
+		{
+			name:    "not HTTP",
+			req:     "CONNECT host.example.com:22 HTTP/1.1\r\nHost: host.example.com:22\r\n\r\n",
+			resp:    "SSH-2.0-OpenSSH_9.6\r\n\r\n",
+			wantErr: `malformed response "SSH-2.0-OpenSSH_9.6"`,
+		},
+		{
+			name:    "header too long",
+			req:     "CONNECT host.example.com:22 HTTP/1.1\r\nHost: host.example.com:22\r\n\r\n",
+			resp:    "HTTP/1.1 200 OK\r\nX: " + strings.Repeat("a", maxConnectResponse),
+			wantErr: "response header too long",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			const banner = "SSH-2.0-OpenSSH_9.6\r\n"
+			done := converse(server, tt.req, tt.resp+banner)
+
+			d := &httpConnectDialer{proxy: "proxy:3128", user: tt.user, header: tt.header}
+			err := d.connect(client, "host.example.com:22")
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("connect: %s", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Fatalf("connect: %v, want %q", err, tt.wantErr)
+			case tt.wantErr == "":
+				// whatever follows the response header is left for ssh
+				got, err := io.ReadAll(client)
+				if err != nil || string(got) != banner {
+					t.Errorf("after the response: %q, %v; want %q", got, err, banner)
+				}
+			}
+			client.Close()
+			if err := <-done; err != nil && !errors.Is(err, io.ErrClosedPipe) {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package srvdial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"jeremy.visser.name/go/ssh-srv/internal/fdpass"
+)
+
+// jumpService is the SRV service advertising jump hosts for a name, as in
+// _ssh-jump._tcp.myserver.mydomain.invalid.
+const jumpService = "ssh-jump"
+
+// ErrNoJump is returned by DialJump when no jump hosts are advertised.
+var ErrNoJump = errors.New("no jump hosts")
+
+// DialJump reaches host through the jump host advertised in its
+// _ssh-jump._tcp record, if any, giving ProxyJump-like behaviour driven by
+// DNS. The jump targets are raced and peeked like any others, then
+// "ssh -W" is run through the winner, and the returned conn is our end of
+// a socketpair connected to its stdio.
+//
+// The destination is the first _ssh._tcp target for host that
+// DialSRVContext would try (see Targets), or host itself on port if there
+// are no SRV records.
+func (sd *SRVDialer) DialJump(ctx context.Context, host, port string) (net.Conn, error) {
+	jc, err := sd.DialSRVContext(ctx, jumpService, "tcp", host)
+	if errors.Is(err, ErrSRVLookup) {
+		return nil, ErrNoJump
+	} else if err != nil {
+		return nil, fmt.Errorf("jump host: %w", err)
+	}
+	// ssh makes its own connection, we only wanted to know which is up:
+	jc.Close()
+	jumpHost := strings.TrimSuffix(jc.Target.Target, ".")
+	jumpPort := strconv.Itoa(int(jc.Target.Port))
+
+	dest := net.JoinHostPort(host, port)
+	_, addrs, err := sd.Targets(ctx, "ssh", "tcp", host)
+	switch {
+	case err == nil:
+		dest = net.JoinHostPort(strings.TrimSuffix(addrs[0].Target, "."), strconv.Itoa(int(addrs[0].Port)))
+	case !errors.Is(err, ErrSRVLookup):
+		// there are records, but none to go to:
+		return nil, err
+	}
+	sd.logf("Jumping to %s via %s:%s", dest, jumpHost, jumpPort)
+
+	fds, err := fdpass.Socketpair()
+	if err != nil {
+		return nil, err
+	}
+	ours := os.NewFile(uintptr(fds[0]), "jump")
+	theirs := os.NewFile(uintptr(fds[1]), "jump")
+	defer ours.Close()
+	defer theirs.Close()
+
+	cmd := exec.Command("ssh", "-p", jumpPort, "-W", dest, jumpHost)
+	cmd.Stdin = theirs
+	cmd.Stdout = theirs
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	// ssh -W carries on after we hand off our end, and if we exit, but is
+	// reaped if it finishes while we're still relaying:
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			sd.logf("ssh -W %s via %s:%s: %s", dest, jumpHost, jumpPort, err)
+		}
+	}()
+
+	return net.FileConn(ours)
+}
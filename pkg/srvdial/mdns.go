@@ -0,0 +1,227 @@
+package srvdial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mdnsTimeout bounds an mDNS query, as there's no telling a name nobody
+// answers for from one that doesn't exist.
+const mdnsTimeout = time.Second
+
+// mdnsAddr is where mDNS queries are sent.
+var mdnsAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// IsLocal reports whether name is under .local, which is looked up with
+// multicast DNS rather than by nameservers.
+func IsLocal(name string) bool {
+	name = normalizeTarget(name)
+	return name == "local" || strings.HasSuffix(name, ".local")
+}
+
+// MDNSResolver is a Resolver for names under .local, which it looks up with
+// multicast DNS (RFC 6762), as Avahi and Bonjour advertise them. It queries
+// the IPv4 group, from its own port, so that the answers come back to it
+// alone. Other names are looked up with Next, or if that's nil,
+// net.DefaultResolver.
+type MDNSResolver struct {
+	Next Resolver
+}
+
+func (r MDNSResolver) next() Resolver {
+	if r.Next == nil {
+		return net.DefaultResolver
+	}
+	return r.Next
+}
+
+// LookupSRV looks up the service at name, as unicast DNS would have it
+// (_ssh._tcp.myhost.local), and as the DNS-SD instance named after the
+// host (myhost._ssh._tcp.local), which is how Avahi advertises sshd.
+func (r MDNSResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if !IsLocal(name) {
+		return r.next().LookupSRV(ctx, service, proto, name)
+	}
+	name = normalizeTarget(name)
+	names := []string{"_" + service + "._" + proto + "." + name}
+	if instance := strings.TrimSuffix(name, ".local"); !strings.Contains(instance, ".") {
+		names = append(names, instance+"._"+service+"._"+proto+".local")
+	}
+
+	rrs, err := mdnsQuery(ctx, false, names, dnsmessage.TypeSRV)
+	if err != nil {
+		return "", nil, err
+	}
+	var cname string
+	var addrs []*net.SRV
+	for _, rr := range rrs {
+		srv, ok := rr.Body.(*dnsmessage.SRVResource)
+		// should both names be answered, only take the first:
+		if !ok || (cname != "" && !strings.EqualFold(cname, rr.Header.Name.String())) {
+			continue
+		}
+		cname = rr.Header.Name.String()
+		addrs = append(addrs, &net.SRV{Target: srv.Target.String(), Port: srv.Port, Priority: srv.Priority, Weight: srv.Weight})
+	}
+	return cname, addrs, nil
+}
+
+// LookupRaw looks up name and qtype with Next (see LookupRaw), or for names
+// under .local, fails: mDNS answers only come as records, not messages.
+func (r MDNSResolver) LookupRaw(ctx context.Context, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	if IsLocal(name) {
+		return nil, fmt.Errorf("lookup %s %s: %w", name, dnsType(qtype), errNoRawLookup)
+	}
+	return LookupRaw(ctx, r.Next, name, qtype)
+}
+
+// ValidatesDNSSEC reports whether Next's AD bit can be believed (see
+// ValidatesDNSSEC), as that's where LookupRaw's answers come from.
+func (r MDNSResolver) ValidatesDNSSEC() bool {
+	return ValidatesDNSSEC(r.Next)
+}
+
+// LookupTXT looks up name's TXT records, each record's strings joined into
+// one.
+func (r MDNSResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if !IsLocal(name) {
+		return LookupTXT(ctx, r.Next, name)
+	}
+	rrs, err := mdnsQuery(ctx, false, []string{normalizeTarget(name)}, dnsmessage.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	var txts []string
+	for _, rr := range rrs {
+		if txt, ok := rr.Body.(*dnsmessage.TXTResource); ok {
+			txts = append(txts, strings.Join(txt.TXT, ""))
+		}
+	}
+	return txts, nil
+}
+
+// LookupIPAddr looks up host's IPv4 and IPv6 addresses.
+func (r MDNSResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if !IsLocal(host) {
+		return r.next().LookupIPAddr(ctx, host)
+	}
+	rrs, err := mdnsQuery(ctx, false, []string{normalizeTarget(host)}, dnsmessage.TypeA, dnsmessage.TypeAAAA)
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IPAddr
+	for _, rr := range rrs {
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = append(ips, net.IPAddr{IP: body.A[:]})
+		case *dnsmessage.AAAAResource:
+			ips = append(ips, net.IPAddr{IP: body.AAAA[:]})
+		}
+	}
+	return ips, nil
+}
+
+// mdnsQuery asks for names with each of qtypes, and returns the matching
+// records from the first response that has any, from its answers and
+// additional records alike, as responders often answer for A with AAAA
+// too. With all, it instead gathers them from every response in
+// mdnsTimeout, as when browsing, where each responder has its own. With no
+// answer in that time, the names are taken not to exist.
+func mdnsQuery(ctx context.Context, all bool, names []string, qtypes ...dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	notFound := &net.DNSError{Err: "no mDNS response", Name: names[0], IsNotFound: true}
+
+	c, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	var pc net.PacketConn = c
+	if dnsLogf != nil {
+		pc = traceDNSConn(c, "udp", mdnsAddr.String()).(net.PacketConn)
+	}
+	defer pc.Close()
+	deadline := time.Now().Add(mdnsTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	pc.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { pc.SetDeadline(time.Now()) })
+	defer stop()
+
+	// One question to a message, as some responders only answer the
+	// first, each with its own ID, which legacy unicast responses echo:
+	want := map[uint16]bool{}
+	for _, name := range names {
+		qname, err := dnsmessage.NewName(name + ".")
+		if err != nil {
+			return nil, err
+		}
+		for _, qtype := range qtypes {
+			q := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: uint16(rand.N(1 << 16))},
+				Questions: []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}},
+			}
+			query, err := q.Pack()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := pc.WriteTo(query, mdnsAddr); err != nil {
+				return nil, err
+			}
+			want[q.ID] = true
+		}
+	}
+
+	buf := make([]byte, 9000) // mDNS allows jumbo frames
+	var rrs []dnsmessage.Resource
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		var ne net.Error
+		switch {
+		case errors.As(err, &ne) && ne.Timeout():
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if len(rrs) == 0 {
+				return nil, notFound
+			}
+			return rrs, nil
+		case err != nil:
+			return nil, err
+		}
+		var m dnsmessage.Message
+		if err := m.Unpack(buf[:n]); err != nil || !m.Response || !want[m.ID] {
+			continue
+		}
+		for _, rr := range append(m.Answers, m.Additionals...) {
+			if mdnsMatch(rr.Header, names, qtypes) && !slices.ContainsFunc(rrs, func(seen dnsmessage.Resource) bool {
+				return seen.Header.Name == rr.Header.Name && seen.Header.Type == rr.Header.Type && seen.Body.GoString() == rr.Body.GoString()
+			}) {
+				rrs = append(rrs, rr)
+			}
+		}
+		if len(rrs) > 0 && !all {
+			return rrs, nil
+		}
+	}
+}
+
+// mdnsMatch reports whether h is for one of names, with one of qtypes.
+func mdnsMatch(h dnsmessage.ResourceHeader, names []string, qtypes []dnsmessage.Type) bool {
+	if !slices.Contains(qtypes, h.Type) {
+		return false
+	}
+	for _, name := range names {
+		if strings.EqualFold(normalizeTarget(h.Name.String()), name) {
+			return true
+		}
+	}
+	return false
+}
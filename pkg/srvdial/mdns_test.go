@@ -0,0 +1,125 @@
+package srvdial
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeMDNS answers mDNS queries sent to mdnsAddr, which it takes over for
+// the test, with whatever answer returns for each question.
+func fakeMDNS(t *testing.T, answer func(q dnsmessage.Question) []dnsmessage.Resource) {
+	t.Helper()
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := mdnsAddr
+	mdnsAddr = c.LocalAddr().(*net.UDPAddr)
+	t.Cleanup(func() {
+		c.Close()
+		mdnsAddr = orig
+	})
+
+	go func() {
+		buf := make([]byte, 9000)
+		for {
+			n, from, err := c.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var q dnsmessage.Message
+			if err := q.Unpack(buf[:n]); err != nil || len(q.Questions) != 1 {
+				continue
+			}
+			rrs := answer(q.Questions[0])
+			if rrs == nil {
+				continue
+			}
+			m := dnsmessage.Message{
+				Header:  dnsmessage.Header{ID: q.ID, Response: true, Authoritative: true},
+				Answers: rrs[:1],
+				// the rest come as additional records, as from Avahi:
+				Additionals: rrs[1:],
+			}
+			if resp, err := m.Pack(); err == nil {
+				c.WriteTo(resp, from)
+			}
+		}
+	}()
+}
+
+func rrHeader(name string, qtype dnsmessage.Type) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: qtype, Class: dnsmessage.ClassINET, TTL: 120}
+}
+
+func TestIsLocal(t *testing.T) {
+	for name, want := range map[string]bool{
+		"myhost.local":     true,
+		"MyHost.Local.":    true,
+		"local":            true,
+		"a.b.local":        true,
+		"myhost.locals":    false,
+		"local.example":    false,
+		"notlocal":         false,
+		"host.example.com": false,
+	} {
+		if got := IsLocal(name); got != want {
+			t.Errorf("IsLocal(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestMDNSResolver(t *testing.T) {
+	fakeMDNS(t, func(q dnsmessage.Question) []dnsmessage.Resource {
+		switch {
+		case q.Name.String() == "myhost._ssh._tcp.local." && q.Type == dnsmessage.TypeSRV:
+			return []dnsmessage.Resource{{
+				Header: rrHeader("myhost._ssh._tcp.local.", dnsmessage.TypeSRV),
+				Body:   &dnsmessage.SRVResource{Target: dnsmessage.MustNewName("myhost.local."), Port: 22},
+			}}
+		case q.Name.String() == "myhost.local." && q.Type == dnsmessage.TypeA:
+			return []dnsmessage.Resource{
+				{Header: rrHeader("myhost.local.", dnsmessage.TypeA), Body: &dnsmessage.AResource{A: [4]byte{192, 168, 1, 2}}},
+				{Header: rrHeader("myhost.local.", dnsmessage.TypeAAAA), Body: &dnsmessage.AAAAResource{AAAA: [16]byte{0: 0xfe, 1: 0x80, 15: 2}}},
+				{Header: rrHeader("other.local.", dnsmessage.TypeA), Body: &dnsmessage.AResource{A: [4]byte{192, 168, 1, 3}}},
+			}
+		}
+		return nil
+	})
+	next := &fakeResolver{srv: map[string][]*net.SRV{"_ssh._tcp.host.example.com": srvs("a.example.com:22")}}
+	r := MDNSResolver{Next: next}
+	ctx := context.Background()
+
+	cname, addrs, err := r.LookupSRV(ctx, "ssh", "tcp", "MyHost.local.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cname != "myhost._ssh._tcp.local." || len(addrs) != 1 || addrs[0].Target != "myhost.local." || addrs[0].Port != 22 {
+		t.Errorf("LookupSRV = %q, %+v", cname, addrs)
+	}
+
+	ips, err := r.LookupIPAddr(ctx, "myhost.local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ip := range ips {
+		got = append(got, ip.String())
+	}
+	slices.Sort(got)
+	if want := []string{"192.168.1.2", "fe80::2"}; !slices.Equal(got, want) {
+		t.Errorf("LookupIPAddr = %q, want %q", got, want)
+	}
+
+	// other names go to Next:
+	if _, addrs, err := r.LookupSRV(ctx, "ssh", "tcp", "host.example.com"); err != nil || len(addrs) != 1 {
+		t.Errorf("LookupSRV of a unicast name = %+v, %v", addrs, err)
+	}
+	if next.lookups.Load() != 1 {
+		t.Errorf("%d lookups by Next, want 1", next.lookups.Load())
+	}
+}
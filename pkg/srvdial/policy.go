@@ -0,0 +1,288 @@
+package srvdial
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A PolicyRule blocks or rewrites SRV targets matching Pattern, or stands
+// in for SRV records of names matching it.
+type PolicyRule struct {
+	Line     int    // line number in the rules file, for logging (0 for -exclude)
+	Action   string // "block", "rewrite", "srv" or "srv-add"
+	Pattern  string // glob (or /regexp/) matched against the target name, or for "srv", the hostname
+	Target   string // replacement target, for "rewrite", or the target, for "srv"
+	Port     uint16 // replacement port, for "rewrite" (0 keeps the original), or the port, for "srv"
+	Priority uint16 // for "srv"
+	Weight   uint16 // for "srv"
+
+	re *regexp.Regexp // for a /regexp/ Pattern
+}
+
+// Policy is a list of local rules applied to SRV answers after resolution,
+// similar in spirit to a DNS response policy zone. The first matching
+// rule wins.
+type Policy []PolicyRule
+
+// LoadPolicy reads rules from a file. Each non-empty line is one of:
+//
+//	block PATTERN
+//	rewrite PATTERN TARGET[:PORT]
+//	srv PATTERN TARGET:PORT [PRIORITY [WEIGHT]]
+//	srv-add PATTERN TARGET:PORT [PRIORITY [WEIGHT]]
+//
+// PATTERN is a glob (see path.Match) compared case-insensitively against
+// the SRV target, without its trailing dot, or a regexp between slashes,
+// like /^bastion[0-9]+\./. Lines starting with # are comments.
+//
+// srv lines are static SRV records, for when DNS can't be changed, or is
+// wrong: their PATTERN is compared against the hostname looked up rather
+// than the target, and their targets are used instead of asking DNS.
+// srv-add lines are added to what DNS says instead. A hostname takes the
+// targets of every line like the first that matches it. Hostname patterns
+// are for _ssh._tcp records; those starting with an underscore, like
+// _imaps._tcp.*.example.com, are compared against the whole SRV name.
+func LoadPolicy(name string) (Policy, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var p Policy
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		r, err := parsePolicyRule(fields)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, n, err)
+		}
+		r.Line = n
+		p = append(p, r)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func parsePolicyRule(fields []string) (PolicyRule, error) {
+	r := PolicyRule{Action: fields[0]}
+	switch {
+	case r.Action == "block" && len(fields) == 2:
+		r.Pattern = fields[1]
+	case r.Action == "rewrite" && len(fields) == 3:
+		r.Pattern = fields[1]
+		r.Target = fields[2]
+		if host, port, err := net.SplitHostPort(fields[2]); err == nil {
+			p, err := strconv.ParseUint(port, 10, 16)
+			if err != nil {
+				return r, fmt.Errorf("invalid port in rewrite target %q", fields[2])
+			}
+			r.Target, r.Port = host, uint16(p)
+		}
+	case (r.Action == "srv" || r.Action == "srv-add") && len(fields) >= 3 && len(fields) <= 5:
+		r.Pattern = fields[1]
+		host, port, err := net.SplitHostPort(fields[2])
+		if err != nil {
+			return r, fmt.Errorf("invalid %s target %q: %w", r.Action, fields[2], err)
+		}
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || p == 0 {
+			return r, fmt.Errorf("invalid port in %s target %q", r.Action, fields[2])
+		}
+		r.Target, r.Port = host, uint16(p)
+		for i, v := range []*uint16{&r.Priority, &r.Weight} {
+			if len(fields) <= 3+i {
+				break
+			}
+			n, err := strconv.ParseUint(fields[3+i], 10, 16)
+			if err != nil {
+				return r, fmt.Errorf("invalid %s %q", []string{"priority", "weight"}[i], fields[3+i])
+			}
+			*v = uint16(n)
+		}
+	default:
+		return r, fmt.Errorf("invalid rule: %q", strings.Join(fields, " "))
+	}
+	if len(r.Pattern) > 2 && strings.HasPrefix(r.Pattern, "/") && strings.HasSuffix(r.Pattern, "/") {
+		re, err := regexp.Compile("(?i)" + r.Pattern[1:len(r.Pattern)-1])
+		if err != nil {
+			return r, fmt.Errorf("invalid pattern %q: %w", r.Pattern, err)
+		}
+		r.re = re
+		return r, nil
+	}
+	r.Pattern = normalizeTarget(r.Pattern)
+	if _, err := path.Match(r.Pattern, ""); err != nil {
+		return r, fmt.Errorf("invalid pattern %q: %w", r.Pattern, err)
+	}
+	return r, nil
+}
+
+// ExcludePolicy returns a Policy blocking targets that match any of
+// patterns, given as for LoadPolicy.
+func ExcludePolicy(patterns []string) (Policy, error) {
+	var p Policy
+	for _, pattern := range patterns {
+		r, err := parsePolicyRule([]string{"block", pattern})
+		if err != nil {
+			return nil, fmt.Errorf("-exclude: %w", err)
+		}
+		p = append(p, r)
+	}
+	return p, nil
+}
+
+// Apply returns addrs with blocked targets removed and rewritten targets
+// replaced. The input slice is not modified.
+func (p Policy) Apply(addrs []*net.SRV) []*net.SRV {
+	return p.apply(addrs, log.Printf)
+}
+
+// apply is Apply, saying what it did to logf.
+func (p Policy) apply(addrs []*net.SRV, logf func(string, ...any)) []*net.SRV {
+	if len(p) == 0 {
+		return addrs
+	}
+
+	var out []*net.SRV
+	for _, addr := range addrs {
+		r := p.match(addr.Target)
+		switch {
+		case r == nil:
+			out = append(out, addr)
+		case r.Action == "block":
+			logf("Policy (%s): blocked %s:%d", r.source(), addr.Target, addr.Port)
+		case r.Action == "rewrite":
+			rw := *addr
+			rw.Target = r.Target
+			if r.Port != 0 {
+				rw.Port = r.Port
+			}
+			logf("Policy (%s): rewrote %s:%d to %s:%d",
+				r.source(), addr.Target, addr.Port, rw.Target, rw.Port)
+			out = append(out, &rw)
+		}
+	}
+	return out
+}
+
+func (p Policy) match(target string) *PolicyRule {
+	target = normalizeTarget(target)
+	for i := range p {
+		if !p[i].isStatic() && p[i].matches(target) {
+			return &p[i]
+		}
+	}
+	return nil
+}
+
+// matches reports whether name (normalized) matches r's Pattern.
+func (r *PolicyRule) matches(name string) bool {
+	if r.re != nil {
+		return r.re.MatchString(name)
+	}
+	ok, _ := path.Match(r.Pattern, name)
+	return ok
+}
+
+// isStatic reports whether r is an srv or srv-add rule.
+func (r *PolicyRule) isStatic() bool {
+	return r.Action == "srv" || r.Action == "srv-add"
+}
+
+// static returns the targets of the srv or srv-add rules for name's
+// _service._proto records: those of the first rule matching it, and of
+// every later one with the same action and pattern. add is whether
+// they're to be added to what DNS says, and r is the first rule, or nil if
+// none matched.
+func (p Policy) static(service, proto, name string) (targets []*net.SRV, add bool, r *PolicyRule) {
+	host := normalizeTarget(name)
+	srvName := "_" + service + "._" + proto + "." + host
+	for i := range p {
+		if !p[i].isStatic() {
+			continue
+		}
+		if r != nil {
+			if p[i].Action == r.Action && p[i].Pattern == r.Pattern {
+				targets = append(targets, p[i].srv())
+			}
+			continue
+		}
+		switch {
+		case strings.HasPrefix(strings.TrimLeft(p[i].Pattern, "/^"), "_"):
+			if !p[i].matches(srvName) {
+				continue
+			}
+		case service != "ssh" || proto != "tcp" || !p[i].matches(host):
+			continue
+		}
+		r = &p[i]
+		targets = append(targets, r.srv())
+	}
+	return targets, r != nil && r.Action == "srv-add", r
+}
+
+// srv returns the SRV record an srv rule stands for.
+func (r *PolicyRule) srv() *net.SRV {
+	return &net.SRV{Target: r.Target, Port: r.Port, Priority: r.Priority, Weight: r.Weight}
+}
+
+// source says where the rule came from, for logging.
+func (r *PolicyRule) source() string {
+	if r.Line == 0 {
+		return "-exclude " + r.Pattern
+	}
+	return fmt.Sprintf("line %d", r.Line)
+}
+
+// normalizeTarget lowercases a DNS name and strips its trailing dot.
+func normalizeTarget(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// Prefer moves targets within any of suffixes (DNS names, like
+// "syd.example.com" or ".syd.example.com") to the front of addrs, the
+// earlier suffixes first, and otherwise keeps the order. The input slice is
+// not modified.
+func Prefer(addrs []*net.SRV, suffixes []string) []*net.SRV {
+	return prefer(addrs, suffixes, log.Printf)
+}
+
+// prefer is Prefer, saying what it did to logf.
+func prefer(addrs []*net.SRV, suffixes []string, logf func(string, ...any)) []*net.SRV {
+	if len(suffixes) == 0 {
+		return addrs
+	}
+	rank := func(addr *net.SRV) int {
+		target := normalizeTarget(addr.Target)
+		for i, suffix := range suffixes {
+			suffix = normalizeTarget(strings.TrimPrefix(suffix, "."))
+			if target == suffix || strings.HasSuffix(target, "."+suffix) {
+				return i
+			}
+		}
+		return len(suffixes)
+	}
+	out := slices.Clone(addrs)
+	slices.SortStableFunc(out, func(a, b *net.SRV) int {
+		return cmp.Compare(rank(a), rank(b))
+	})
+	if !slices.Equal(out, addrs) {
+		logf("Trying targets in %s first (see -prefer)", strings.Join(suffixes, ", "))
+	}
+	return out
+}
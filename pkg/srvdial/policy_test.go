@@ -0,0 +1,134 @@
+package srvdial
+
+import (
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParsePolicyRule(t *testing.T) {
+	tests := []struct {
+		line string
+		want PolicyRule // without re
+		ok   bool
+	}{
+		{"block bastion1.example.com", PolicyRule{Action: "block", Pattern: "bastion1.example.com"}, true},
+		{"block Bastion*.Example.COM.", PolicyRule{Action: "block", Pattern: "bastion*.example.com"}, true},
+		{"block /^bastion[0-9]+\\./", PolicyRule{Action: "block", Pattern: "/^bastion[0-9]+\\./"}, true},
+		{"rewrite old.example.com new.example.com", PolicyRule{Action: "rewrite", Pattern: "old.example.com", Target: "new.example.com"}, true},
+		{"rewrite old.example.com new.example.com:2222", PolicyRule{Action: "rewrite", Pattern: "old.example.com", Target: "new.example.com", Port: 2222}, true},
+		{"srv host.example.com a.example.com:22", PolicyRule{Action: "srv", Pattern: "host.example.com", Target: "a.example.com", Port: 22}, true},
+		{"srv host.example.com a.example.com:22 10", PolicyRule{Action: "srv", Pattern: "host.example.com", Target: "a.example.com", Port: 22, Priority: 10}, true},
+		{"srv-add *.example.com a.example.com:22 10 5", PolicyRule{Action: "srv-add", Pattern: "*.example.com", Target: "a.example.com", Port: 22, Priority: 10, Weight: 5}, true},
+		{"srv _imaps._tcp.example.com mail.example.com:993", PolicyRule{Action: "srv", Pattern: "_imaps._tcp.example.com", Target: "mail.example.com", Port: 993}, true},
+
+		{"block", PolicyRule{}, false},
+		{"block a b", PolicyRule{}, false},
+		{"allow host.example.com", PolicyRule{}, false},
+		{"block [a-", PolicyRule{}, false},
+		{"block /(/", PolicyRule{}, false},
+		{"rewrite old.example.com", PolicyRule{}, false},
+		{"rewrite old.example.com new.example.com:ssh", PolicyRule{}, false},
+		{"srv host.example.com a.example.com", PolicyRule{}, false},
+		{"srv host.example.com a.example.com:0", PolicyRule{}, false},
+		{"srv host.example.com a.example.com:65536", PolicyRule{}, false},
+		{"srv host.example.com a.example.com:22 high", PolicyRule{}, false},
+		{"srv host.example.com a.example.com:22 0 heavy", PolicyRule{}, false},
+		{"srv host.example.com a.example.com:22 0 0 extra", PolicyRule{}, false},
+	}
+	for _, tt := range tests {
+		got, err := parsePolicyRule(strings.Fields(tt.line))
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("parsePolicyRule(%q): %v, want ok %v", tt.line, err, tt.ok)
+			continue
+		}
+		got.re = nil
+		if tt.ok && got != tt.want {
+			t.Errorf("parsePolicyRule(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyRuleMatches(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"bastion*.example.com", "bastion1.example.com", true},
+		{"Bastion*.example.com", "bastion1.example.com", true},
+		{"bastion*.example.com", "bastion1.other.example.com", true},
+		{"bastion?.example.com", "bastion12.example.com", false},
+		{"*.example.com", "example.com", false},
+		{"/^bastion[0-9]+\\./", "bastion12.example.com", true},
+		{"/^bastion[0-9]+\\./", "Bastion12.example.com", true},
+		{"/^bastion[0-9]+\\./", "old-bastion12.example.com", false},
+		{"/maint/", "db-maint-1.example.com", true},
+	}
+	for _, tt := range tests {
+		r, err := parsePolicyRule([]string{"block", tt.pattern})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.matches(normalizeTarget(tt.name)); got != tt.want {
+			t.Errorf("%q matches %q = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyApply(t *testing.T) {
+	var p Policy
+	for _, line := range []string{
+		"block bastion1.*",
+		"srv old.example.com static.example.com:22",
+		"rewrite old.example.com new.example.com:2222",
+		"rewrite *.example.com any.example.com",
+	} {
+		r, err := parsePolicyRule(strings.Fields(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p = append(p, r)
+	}
+	addrs := []*net.SRV{
+		{Target: "bastion1.example.com.", Port: 22},
+		{Target: "Old.Example.com.", Port: 22},
+		{Target: "other.example.com.", Port: 22},
+		{Target: "host.example.net.", Port: 22},
+	}
+	var got []string
+	for _, addr := range p.apply(addrs, t.Logf) {
+		got = append(got, net.JoinHostPort(addr.Target, strconv.Itoa(int(addr.Port))))
+	}
+	want := []string{"new.example.com:2222", "any.example.com:22", "host.example.net.:22"}
+	if !slices.Equal(got, want) {
+		t.Errorf("apply = %q, want %q", got, want)
+	}
+	if addrs[1].Target != "Old.Example.com." {
+		t.Errorf("apply modified its input: %+v", addrs[1])
+	}
+}
+
+func TestExcludePolicy(t *testing.T) {
+	p, err := ExcludePolicy([]string{"bastion1.*", "/maint/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := []*net.SRV{
+		{Target: "bastion1.example.com.", Port: 22},
+		{Target: "bastion2.example.com.", Port: 22},
+		{Target: "maint3.example.com.", Port: 22},
+	}
+	var got []string
+	for _, addr := range p.apply(addrs, t.Logf) {
+		got = append(got, TargetKey(addr))
+	}
+	if want := []string{"bastion2.example.com:22"}; !slices.Equal(got, want) {
+		t.Errorf("apply = %q, want %q", got, want)
+	}
+
+	if _, err := ExcludePolicy([]string{"[a-"}); err == nil || !strings.HasPrefix(err.Error(), "-exclude: ") {
+		t.Errorf("ExcludePolicy of an invalid pattern: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package srvdial
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ContextDialer dials network connections. *net.Dialer and the proxy
+// dialers implement it, as do those from golang.org/x/net/proxy, and
+// userspace network stacks can through DialerFunc.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// DialerFunc adapts a dial func, such as a userspace network stack's or an
+// in-memory network's, to a ContextDialer.
+type DialerFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+func (f DialerFunc) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return f(ctx, network, address)
+}
+
+// NewProxyDialer returns a dialer connecting through the proxy at rawURL,
+// reaching the proxy itself with forward.
+//
+// Proxy dialers return the connection to the proxy itself once the tunnel
+// is established, so the socket can still be peeked and handed to ssh.
+func NewProxyDialer(rawURL string, header []string, forward ContextDialer) (ContextDialer, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		return &socks5Dialer{
+			proxy:   hostPortDefault(u, "1080"),
+			user:    u.User,
+			forward: forward,
+		}, nil
+	case "http":
+		for _, h := range header {
+			if name, _, ok := strings.Cut(h, ":"); !ok || strings.TrimSpace(name) == "" {
+				return nil, fmt.Errorf("invalid proxy header %q", h)
+			}
+		}
+		return &httpConnectDialer{
+			proxy:   hostPortDefault(u, "80"),
+			user:    u.User,
+			header:  header,
+			forward: forward,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+}
+
+// hostPortDefault returns u's host:port, using port if u doesn't have one.
+func hostPortDefault(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// handshakeDeadline applies ctx's deadline and cancellation to c while a
+// proxy handshake is in progress. The returned func restores c.
+func handshakeDeadline(ctx context.Context, c net.Conn) (restore func()) {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		c.SetDeadline(time.Unix(1, 0)) // unblock any pending I/O
+	})
+	return func() {
+		stop()
+		c.SetDeadline(time.Time{})
+	}
+}
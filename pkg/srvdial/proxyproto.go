@@ -0,0 +1,91 @@
+package srvdial
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// proxyProtoDialer sends a HAProxy PROXY protocol header on each new
+// connection, before anything else (including the peek) reads from it.
+type proxyProtoDialer struct {
+	version int // 1 or 2
+	next    ContextDialer
+}
+
+// NewProxyProtoDialer returns a dialer sending a HAProxy PROXY protocol
+// header of version ("v1" or "v2") on each connection made with next.
+func NewProxyProtoDialer(version string, next ContextDialer) (ContextDialer, error) {
+	switch version {
+	case "v1", "1":
+		return &proxyProtoDialer{version: 1, next: next}, nil
+	case "v2", "2":
+		return &proxyProtoDialer{version: 2, next: next}, nil
+	default:
+		return nil, fmt.Errorf("unknown PROXY protocol version %q (want v1 or v2)", version)
+	}
+}
+
+func (d *proxyProtoDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	c, err := d.next.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	var hdr []byte
+	if d.version == 1 {
+		hdr = proxyHeaderV1(c.LocalAddr(), c.RemoteAddr())
+	} else {
+		hdr = proxyHeaderV2(c.LocalAddr(), c.RemoteAddr())
+	}
+	if _, err := c.Write(hdr); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("sending PROXY header: %w", err)
+	}
+	return c, nil
+}
+
+// proxyHeaderV1 returns the human-readable form of the header.
+func proxyHeaderV1(src, dst net.Addr) []byte {
+	s, ok1 := src.(*net.TCPAddr)
+	d, ok2 := dst.(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	fam := "TCP4"
+	if s.IP.To4() == nil {
+		fam = "TCP6"
+	}
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n",
+		fam, s.IP, d.IP, s.Port, d.Port))
+}
+
+// proxyV2Sig starts every version 2 header.
+const proxyV2Sig = "\r\n\r\n\x00\r\nQUIT\n"
+
+// proxyHeaderV2 returns the binary form of the header.
+func proxyHeaderV2(src, dst net.Addr) []byte {
+	hdr := []byte(proxyV2Sig)
+	s, ok1 := src.(*net.TCPAddr)
+	d, ok2 := dst.(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		// LOCAL command, no address block:
+		return append(hdr, 0x20, 0x00, 0, 0)
+	}
+
+	var addrs []byte
+	fam := byte(0x11) // TCP over IPv4
+	if s4, d4 := s.IP.To4(), d.IP.To4(); s4 != nil && d4 != nil {
+		addrs = append(append(addrs, s4...), d4...)
+	} else {
+		fam = 0x21 // TCP over IPv6
+		addrs = append(append(addrs, s.IP.To16()...), d.IP.To16()...)
+	}
+	addrs = binary.BigEndian.AppendUint16(addrs, uint16(s.Port))
+	addrs = binary.BigEndian.AppendUint16(addrs, uint16(d.Port))
+
+	hdr = append(hdr, 0x21, fam) // version 2, PROXY command
+	hdr = binary.BigEndian.AppendUint16(hdr, uint16(len(addrs)))
+	return append(hdr, addrs...)
+}
@@ -0,0 +1,61 @@
+package srvdial
+
+import (
+	"net"
+	"testing"
+)
+
+func TestProxyHeader(t *testing.T) {
+	v4src := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 56324}
+	v4dst := &net.TCPAddr{IP: net.IPv4(198, 51, 100, 2), Port: 22}
+	v6src := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324}
+	v6dst := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 22}
+	unix := &net.UnixAddr{Name: "/run/sshd.sock", Net: "unix"}
+
+	tests := []struct {
+		name     string
+		src, dst net.Addr
+		v1, v2   string
+	}{
+		{
+			"IPv4", v4src, v4dst,
+			"PROXY TCP4 192.0.2.1 198.51.100.2 56324 22\r\n",
+			proxyV2Sig + "\x21\x11\x00\x0c\xc0\x00\x02\x01\xc6\x33\x64\x02\xdc\x04\x00\x16",
+		},
+		{
+			"IPv6", v6src, v6dst,
+			"PROXY TCP6 2001:db8::1 2001:db8::2 56324 22\r\n",
+			proxyV2Sig + "\x21\x21\x00\x24" +
+				"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01" +
+				"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02" +
+				"\xdc\x04\x00\x16",
+		},
+		{
+			"not TCP", unix, unix,
+			"PROXY UNKNOWN\r\n",
+			proxyV2Sig + "\x20\x00\x00\x00",
+		},
+	}
+	for _, tt := range tests {
+		if got := string(proxyHeaderV1(tt.src, tt.dst)); got != tt.v1 {
+			t.Errorf("%s: proxyHeaderV1 = %q, want %q", tt.name, got, tt.v1)
+		}
+		if got := string(proxyHeaderV2(tt.src, tt.dst)); got != tt.v2 {
+			t.Errorf("%s: proxyHeaderV2 = %q, want %q", tt.name, got, tt.v2)
+		}
+	}
+}
+
+func TestNewProxyProtoDialer(t *testing.T) {
+	for in, want := range map[string]int{"v1": 1, "1": 1, "v2": 2, "2": 2, "v3": 0, "": 0} {
+		d, err := NewProxyProtoDialer(in, nil)
+		switch {
+		case want == 0 && err == nil:
+			t.Errorf("NewProxyProtoDialer(%q) succeeded, want an error", in)
+		case want != 0 && err != nil:
+			t.Errorf("NewProxyProtoDialer(%q): %s", in, err)
+		case want != 0 && d.(*proxyProtoDialer).version != want:
+			t.Errorf("NewProxyProtoDialer(%q) has version %d, want %d", in, d.(*proxyProtoDialer).version, want)
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package srvdial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testConn is what the attempts in these tests connect to, noting whether
+// Race closed it as a loser.
+type testConn struct {
+	id     int
+	closed atomic.Bool
+}
+
+func (c *testConn) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+// attempts are funcs for Race that each say when they start, then wait to
+// be told how to finish, or for ctx to be done.
+type attempts struct {
+	t       *testing.T
+	started chan int
+	finish  []chan error
+	conns   []*testConn
+
+	// ignoreCancel makes them connect when ctx is done, rather than
+	// failing, as an attempt that completes just as it's abandoned does.
+	ignoreCancel bool
+
+	mu      sync.Mutex
+	running int
+	peak    int // the most that were running at once
+}
+
+func newAttempts(t *testing.T, n int) *attempts {
+	a := &attempts{t: t, started: make(chan int, n)}
+	for i := range n {
+		a.finish = append(a.finish, make(chan error, 1))
+		a.conns = append(a.conns, &testConn{id: i})
+	}
+	return a
+}
+
+func (a *attempts) funcs() []func(context.Context) (*testConn, error) {
+	var fs []func(context.Context) (*testConn, error)
+	for i := range a.finish {
+		fs = append(fs, func(ctx context.Context) (*testConn, error) {
+			a.mu.Lock()
+			a.running++
+			a.peak = max(a.peak, a.running)
+			a.mu.Unlock()
+			defer func() {
+				a.mu.Lock()
+				a.running--
+				a.mu.Unlock()
+			}()
+
+			a.started <- i
+			select {
+			case err := <-a.finish[i]:
+				if err != nil {
+					return nil, err
+				}
+			case <-ctx.Done():
+				if !a.ignoreCancel {
+					return nil, context.Cause(ctx)
+				}
+			}
+			return a.conns[i], nil
+		})
+	}
+	return fs
+}
+
+// waitStart fails the test unless attempt i is the next to start.
+func (a *attempts) waitStart(i int) {
+	a.t.Helper()
+	select {
+	case got := <-a.started:
+		if got != i {
+			a.t.Fatalf("attempt %d started, want %d", got, i)
+		}
+	case <-time.After(5 * time.Second):
+		a.t.Fatalf("attempt %d never started", i)
+	}
+}
+
+// waitStarts fails the test unless attempts ids are the next to start, in
+// any order, as those started at once may.
+func (a *attempts) waitStarts(ids ...int) {
+	a.t.Helper()
+	var got []int
+	for range ids {
+		select {
+		case i := <-a.started:
+			got = append(got, i)
+		case <-time.After(5 * time.Second):
+			a.t.Fatalf("attempts %v started, want %v", got, ids)
+		}
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, ids) {
+		a.t.Fatalf("attempts %v started, want %v", got, ids)
+	}
+}
+
+// noneStarted fails the test if any attempt started that wasn't waited for.
+func (a *attempts) noneStarted() {
+	a.t.Helper()
+	select {
+	case got := <-a.started:
+		a.t.Fatalf("attempt %d started, want none", got)
+	default:
+	}
+}
+
+type raceResult struct {
+	conn *testConn
+	err  error
+}
+
+// startRace runs race in the background, returning where its result goes.
+func startRace(ctx context.Context, clock Clock, a *attempts, stagger func(int) time.Duration, limit int) <-chan raceResult {
+	res := make(chan raceResult, 1)
+	go func() {
+		conn, err := race(ctx, clock, a.funcs(), stagger, limit)
+		res <- raceResult{conn, err}
+	}()
+	return res
+}
+
+func waitResult(t *testing.T, res <-chan raceResult) raceResult {
+	t.Helper()
+	select {
+	case r := <-res:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("race never returned")
+		return raceResult{}
+	}
+}
+
+func every(d time.Duration) func(int) time.Duration {
+	return func(int) time.Duration { return d }
+}
+
+func TestRaceStagger(t *testing.T) {
+	clock := newFakeClock()
+	a := newAttempts(t, 3)
+	res := startRace(context.Background(), clock, a, every(300*time.Millisecond), 0)
+
+	a.waitStart(0)
+	clock.WaitTimers(1)
+	clock.Advance(299 * time.Millisecond)
+	if clock.Pending() != 1 {
+		t.Fatal("stagger fired early")
+	}
+	clock.Advance(time.Millisecond)
+	a.waitStart(1)
+
+	a.finish[1] <- nil
+	r := waitResult(t, res)
+	if r.err != nil || r.conn.id != 1 {
+		t.Fatalf("race = %v, %v; want attempt 1", r.conn, r.err)
+	}
+	// once there's a winner, nothing more is started:
+	a.noneStarted()
+	if clock.Pending() != 0 {
+		t.Errorf("%d timers left running", clock.Pending())
+	}
+}
+
+func TestRaceFailureSkipsStagger(t *testing.T) {
+	clock := newFakeClock()
+	a := newAttempts(t, 3)
+	res := startRace(context.Background(), clock, a, every(time.Hour), 0)
+
+	a.waitStart(0)
+	a.finish[0] <- errors.New("refused")
+	// no time passes: a failure moves straight on to the next
+	a.waitStart(1)
+	a.finish[1] <- nil
+
+	if r := waitResult(t, res); r.err != nil || r.conn.id != 1 {
+		t.Fatalf("race = %v, %v; want attempt 1", r.conn, r.err)
+	}
+}
+
+func TestStagger(t *testing.T) {
+	addrs := []*net.SRV{{Priority: 0}, {Priority: 0}, {Priority: 1}, {Priority: 1}}
+	tests := []struct {
+		name string
+		sd   SRVDialer
+		want []time.Duration // for addrs[1:]
+	}{
+		{"defaults", SRVDialer{}, []time.Duration{DefaultStagger, DefaultStagger, DefaultStagger}},
+		{"stagger only", SRVDialer{Stagger: time.Second}, []time.Duration{time.Second, time.Second, time.Second}},
+		{
+			"priority stagger",
+			SRVDialer{Stagger: 100 * time.Millisecond, PriorityStagger: 2 * time.Second},
+			[]time.Duration{100 * time.Millisecond, 2 * time.Second, 100 * time.Millisecond},
+		},
+		{"all", SRVDialer{Fanout: FanoutAll, Stagger: time.Second}, []time.Duration{0, 0, 0}},
+		{"fastest", SRVDialer{Fanout: FanoutFastest}, []time.Duration{0, 0, 0}},
+		{"sequential", SRVDialer{Fanout: FanoutSequential}, []time.Duration{math.MaxInt64, math.MaxInt64, math.MaxInt64}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stagger := tt.sd.stagger(addrs)
+			var got []time.Duration
+			for i := 1; i < len(addrs); i++ {
+				got = append(got, stagger(i))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("stagger = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRacePriorityStagger(t *testing.T) {
+	clock := newFakeClock()
+	addrs := []*net.SRV{{Priority: 0}, {Priority: 0}, {Priority: 1}}
+	sd := SRVDialer{Stagger: 100 * time.Millisecond, PriorityStagger: time.Second}
+	a := newAttempts(t, 3)
+	res := startRace(context.Background(), clock, a, sd.stagger(addrs), 0)
+
+	a.waitStart(0)
+	clock.WaitTimers(1)
+	clock.Advance(100 * time.Millisecond)
+	a.waitStart(1)
+	clock.WaitTimers(1)
+	clock.Advance(999 * time.Millisecond)
+	a.noneStarted()
+	clock.Advance(time.Millisecond)
+	a.waitStart(2)
+
+	a.finish[2] <- nil
+	if r := waitResult(t, res); r.err != nil || r.conn.id != 2 {
+		t.Fatalf("race = %v, %v; want attempt 2", r.conn, r.err)
+	}
+	if want := []time.Duration{100 * time.Millisecond, time.Second}; !slices.Equal(clock.Waits(), want) {
+		t.Errorf("timers started with %v, want %v", clock.Waits(), want)
+	}
+}
+
+func TestRaceSequential(t *testing.T) {
+	clock := newFakeClock()
+	sd := SRVDialer{Fanout: FanoutSequential}
+	a := newAttempts(t, 2)
+	res := startRace(context.Background(), clock, a, sd.stagger(nil), 0)
+
+	a.waitStart(0)
+	clock.WaitTimers(1)
+	clock.Advance(24 * time.Hour)
+	a.noneStarted()
+	if clock.Pending() != 1 {
+		t.Fatal("sequential timer fired")
+	}
+
+	a.finish[0] <- errors.New("refused")
+	a.waitStart(1)
+	a.finish[1] <- nil
+	if r := waitResult(t, res); r.err != nil || r.conn.id != 1 {
+		t.Fatalf("race = %v, %v; want attempt 1", r.conn, r.err)
+	}
+}
+
+func TestRaceMaxParallel(t *testing.T) {
+	clock := newFakeClock()
+	a := newAttempts(t, 4)
+	res := startRace(context.Background(), clock, a, every(0), 2)
+
+	a.waitStarts(0, 1)
+	a.noneStarted()
+	a.finish[0] <- errors.New("refused")
+	a.waitStart(2)
+	a.finish[1] <- errors.New("refused")
+	a.waitStart(3)
+	a.finish[2] <- errors.New("refused")
+	a.finish[3] <- nil
+
+	if r := waitResult(t, res); r.err != nil || r.conn.id != 3 {
+		t.Fatalf("race = %v, %v; want attempt 3", r.conn, r.err)
+	}
+	if a.peak > 2 {
+		t.Errorf("%d attempts ran at once, want at most 2", a.peak)
+	}
+}
+
+func TestRaceClosesLosers(t *testing.T) {
+	clock := newFakeClock()
+	a := newAttempts(t, 3)
+	a.ignoreCancel = true
+	res := startRace(context.Background(), clock, a, every(0), 0)
+
+	a.waitStarts(0, 1, 2)
+	a.finish[0] <- nil
+	r := waitResult(t, res)
+	if r.err != nil || r.conn.id != 0 {
+		t.Fatalf("race = %v, %v; want attempt 0", r.conn, r.err)
+	}
+
+	// race waited for the losers, which connected as they were cancelled:
+	for i, c := range a.conns {
+		if want := i != 0; c.closed.Load() != want {
+			t.Errorf("attempt %d closed = %v, want %v", i, c.closed.Load(), want)
+		}
+	}
+}
+
+func TestRaceJoinsErrors(t *testing.T) {
+	clock := newFakeClock()
+	a := newAttempts(t, 3)
+	res := startRace(context.Background(), clock, a, every(0), 0)
+
+	a.waitStarts(0, 1, 2)
+	var errs []error
+	for i := range 3 {
+		errs = append(errs, fmt.Errorf("attempt %d refused", i))
+	}
+	for i, err := range errs {
+		a.finish[i] <- err
+	}
+
+	r := waitResult(t, res)
+	for _, err := range errs {
+		if !errors.Is(r.err, err) {
+			t.Errorf("race error %q doesn't include %q", r.err, err)
+		}
+	}
+}
+
+func TestRaceCancelled(t *testing.T) {
+	clock := newFakeClock()
+	cause := errors.New("out of time")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	a := newAttempts(t, 3)
+	res := startRace(ctx, clock, a, every(time.Second), 0)
+
+	a.waitStart(0)
+	clock.WaitTimers(1)
+	cancel(cause)
+
+	r := waitResult(t, res)
+	if !errors.Is(r.err, cause) {
+		t.Errorf("race error %q doesn't include the cause %q", r.err, cause)
+	}
+	a.noneStarted()
+	if clock.Pending() != 0 {
+		t.Errorf("%d timers left running", clock.Pending())
+	}
+}
+
+func TestRaceEdges(t *testing.T) {
+	if _, err := race[*testConn](context.Background(), newFakeClock(), nil, every(0), 0); err == nil {
+		t.Error("race of nothing succeeded")
+	}
+
+	// a single attempt has the whole of ctx to itself, with no timers:
+	clock := newFakeClock()
+	a := newAttempts(t, 1)
+	res := startRace(context.Background(), clock, a, every(time.Second), 0)
+	a.waitStart(0)
+	a.finish[0] <- nil
+	if r := waitResult(t, res); r.err != nil || r.conn.id != 0 {
+		t.Fatalf("race = %v, %v; want attempt 0", r.conn, r.err)
+	}
+	if len(clock.Waits()) != 0 {
+		t.Errorf("timers started for a single attempt: %v", clock.Waits())
+	}
+}
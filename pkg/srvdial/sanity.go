@@ -0,0 +1,85 @@
+package srvdial
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// CheckSRV looks for mistakes in an SRV answer, returning the records worth
+// trying, and a description of each problem found. Records that can't work,
+// like those with port 0 or repeating an earlier one, are left out rather
+// than using up a place in the race.
+func CheckSRV(addrs []*net.SRV) ([]*net.SRV, []string) {
+	var out []*net.SRV
+	var problems []string
+	seen := map[string]bool{}
+	for _, addr := range addrs {
+		key := TargetKey(addr)
+		switch {
+		case addr.Target == ".":
+			problems = append(problems, "ignoring a target of \".\" (service not available) alongside other records")
+			continue
+		case addr.Port == 0:
+			problems = append(problems, fmt.Sprintf("skipping %s: port 0", key))
+			continue
+		case seen[key]:
+			problems = append(problems, fmt.Sprintf("skipping %s: listed more than once", key))
+			continue
+		case net.ParseIP(normalizeTarget(addr.Target)) != nil:
+			problems = append(problems, fmt.Sprintf("%s: SRV targets should be hostnames, not addresses", key))
+		}
+		seen[key] = true
+		out = append(out, addr)
+	}
+
+	// Within a priority, targets of weight 0 alongside weighted ones are
+	// hardly ever picked first (see shuffleByWeight), which is rarely what
+	// was meant:
+	zero := map[uint16]int{}
+	weighted := map[uint16]int{}
+	for _, addr := range out {
+		if addr.Weight == 0 {
+			zero[addr.Priority]++
+		} else {
+			weighted[addr.Priority]++
+		}
+	}
+	for _, addr := range out {
+		if n := zero[addr.Priority]; n > 0 && weighted[addr.Priority] > 0 {
+			problems = append(problems, fmt.Sprintf("priority %d: %d target(s) of weight 0 will rarely be tried before the weighted ones", addr.Priority, n))
+			delete(zero, addr.Priority) // once is enough
+		}
+	}
+	return out, problems
+}
+
+// CheckResolvable looks up the addresses of each of addrs at once,
+// returning a description of each target that doesn't resolve. Onion
+// names, IP literals and targets given addresses with Resolve aren't
+// looked up. It's for reports like "ssh-srv list"; dialing finds out
+// anyway.
+func (sd *SRVDialer) CheckResolvable(ctx context.Context, addrs []*net.SRV) []string {
+	problems := make([]string, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		host := normalizeTarget(addr.Target)
+		if _, pinned := sd.ResolveOverride(host, strconv.Itoa(int(addr.Port))); pinned || IsOnion(host) || net.ParseIP(host) != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lctx, cancel := sd.lookupContext(ctx)
+			defer cancel()
+			if _, err := sd.resolver().LookupIPAddr(lctx, host); err != nil {
+				problems[i] = fmt.Sprintf("%s: doesn't resolve: %s", TargetKey(addr), err)
+			}
+		}()
+	}
+	wg.Wait()
+	return slices.DeleteFunc(problems, func(p string) bool { return p == "" })
+}
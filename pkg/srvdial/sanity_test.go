@@ -0,0 +1,119 @@
+package srvdial
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCheckSRV(t *testing.T) {
+	tests := []struct {
+		name     string
+		addrs    []*net.SRV
+		want     []string // TargetKeys of what's kept
+		problems []string // substrings, one for each problem
+	}{
+		{
+			name:  "fine",
+			addrs: []*net.SRV{{Target: "a.example.com.", Port: 22, Weight: 10}, {Target: "b.example.com.", Port: 2222, Weight: 10}},
+			want:  []string{"a.example.com:22", "b.example.com:2222"},
+		},
+		{
+			name:     "duplicate",
+			addrs:    []*net.SRV{{Target: "a.example.com.", Port: 22}, {Target: "A.Example.com", Port: 22}, {Target: "a.example.com.", Port: 2222}},
+			want:     []string{"a.example.com:22", "a.example.com:2222"},
+			problems: []string{"skipping a.example.com:22: listed more than once"},
+		},
+		{
+			name:     "dot",
+			addrs:    []*net.SRV{{Target: ".", Port: 0}, {Target: "a.example.com.", Port: 22}},
+			want:     []string{"a.example.com:22"},
+			problems: []string{`ignoring a target of "."`},
+		},
+		{
+			name:     "port 0",
+			addrs:    []*net.SRV{{Target: "a.example.com.", Port: 0}, {Target: "b.example.com.", Port: 22}},
+			want:     []string{"b.example.com:22"},
+			problems: []string{"skipping a.example.com:0: port 0"},
+		},
+		{
+			name:     "IP literal",
+			addrs:    []*net.SRV{{Target: "192.0.2.1.", Port: 22}},
+			want:     []string{"192.0.2.1:22"},
+			problems: []string{"192.0.2.1:22: SRV targets should be hostnames"},
+		},
+		{
+			name:     "duplicate IP literal",
+			addrs:    []*net.SRV{{Target: "192.0.2.1.", Port: 22}, {Target: "192.0.2.1", Port: 22}, {Target: "198.51.100.1", Port: 22}},
+			want:     []string{"192.0.2.1:22", "198.51.100.1:22"},
+			problems: []string{"192.0.2.1:22: SRV targets should be hostnames", "skipping 192.0.2.1:22: listed more than once", "198.51.100.1:22: SRV targets should be hostnames"},
+		},
+		{
+			name:     "more than one dot",
+			addrs:    []*net.SRV{{Target: "."}, {Target: "a.example.com.", Port: 22}, {Target: "."}},
+			want:     []string{"a.example.com:22"},
+			problems: []string{`ignoring a target of "."`, `ignoring a target of "."`},
+		},
+		{
+			name:     "only port 0",
+			addrs:    []*net.SRV{{Target: "a.example.com.", Port: 0}, {Target: "a.example.com.", Port: 0}},
+			problems: []string{"skipping a.example.com:0: port 0", "skipping a.example.com:0: port 0"},
+		},
+		{
+			name: "weight 0",
+			addrs: []*net.SRV{
+				{Target: "a.example.com.", Port: 22, Priority: 10},
+				{Target: "b.example.com.", Port: 22, Priority: 10, Weight: 5},
+				{Target: "c.example.com.", Port: 22, Priority: 20},
+			},
+			want:     []string{"a.example.com:22", "b.example.com:22", "c.example.com:22"},
+			problems: []string{"priority 10: 1 target(s) of weight 0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, problems := CheckSRV(tt.addrs)
+			var got []string
+			for _, addr := range out {
+				got = append(got, TargetKey(addr))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("CheckSRV kept %q, want %q", got, tt.want)
+			}
+			if len(problems) != len(tt.problems) {
+				t.Fatalf("CheckSRV found %q, want %q", problems, tt.problems)
+			}
+			for i, p := range problems {
+				if !strings.Contains(p, tt.problems[i]) {
+					t.Errorf("problem %q, want one about %q", p, tt.problems[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCheckResolvable(t *testing.T) {
+	sd := &SRVDialer{
+		Resolver: &fakeResolver{},
+		Resolve:  map[string][]net.IPAddr{"pinned.invalid:22": {{IP: net.IPv4(192, 0, 2, 2)}}},
+	}
+	addrs := []*net.SRV{
+		{Target: "a.example.com.", Port: 22},
+		{Target: "gone.invalid.", Port: 22},
+		{Target: "pinned.invalid.", Port: 22},
+		{Target: "192.0.2.1", Port: 22},
+		{Target: "gone.invalid.", Port: 2222},
+	}
+	want := []string{"gone.invalid:22: doesn't resolve", "gone.invalid:2222: doesn't resolve"}
+	problems := sd.CheckResolvable(context.Background(), addrs)
+	if len(problems) != len(want) {
+		t.Fatalf("CheckResolvable found %q, want %q", problems, want)
+	}
+	for i, p := range problems {
+		if !strings.HasPrefix(p, want[i]) {
+			t.Errorf("problem %q, want one starting %q", p, want[i])
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package srvdial
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SocketOptions are TCP settings for a connection that outlives the dial,
+// such as one handed to ssh. The zero value changes nothing.
+type SocketOptions struct {
+	// KeepAlive, if positive, sends keepalive probes once the connection
+	// has been idle that long, and then that often until one is answered.
+	// If negative, it turns them off.
+	KeepAlive time.Duration
+
+	// KeepAliveCount, if positive, is how many unanswered probes it takes
+	// for the connection to be dropped.
+	KeepAliveCount int
+
+	// UserTimeout, if positive, drops the connection once data sent on it
+	// has gone unacknowledged that long (TCP_USER_TIMEOUT, Linux only),
+	// rather than after the kernel's many minutes of retransmissions. It
+	// also bounds how long unanswered keepalives take to drop it.
+	UserTimeout time.Duration
+
+	// Nagle turns Nagle's algorithm back on (clearing TCP_NODELAY, which
+	// Go sets on every TCP connection), trading latency for fewer, fuller
+	// packets.
+	Nagle bool
+
+	// SendBuffer and ReceiveBuffer, if positive, size the socket's buffers
+	// in bytes (SO_SNDBUF and SO_RCVBUF), which also turns off the
+	// kernel's autotuning of them. Linux doubles them for bookkeeping.
+	SendBuffer    int
+	ReceiveBuffer int
+
+	// TOS, if non-zero, is the IPv4 TOS or IPv6 traffic class byte, with
+	// the DSCP in its top six bits, as from ParseIPQoS. Control sets it
+	// before connecting, so the handshake is marked too. ssh sets its own
+	// IPQoS on a socket it's handed, once the session starts.
+	TOS int
+
+	// Mark, if non-zero, sets the socket's firewall mark (SO_MARK, Linux
+	// only), for policy routing rules to steer it by. It needs
+	// CAP_NET_ADMIN (or CAP_NET_RAW, since Linux 5.17).
+	Mark uint32
+
+	// Interface, if set, binds the socket to that network interface, or
+	// VRF device, so it's only ever sent and routed out of it
+	// (SO_BINDTODEVICE on Linux, IP_BOUND_IF on macOS), for hosts with
+	// more than one uplink. Control sets it before connecting.
+	Interface string
+
+	// FastOpen asks for TCP Fast Open (TCP_FASTOPEN_CONNECT, Linux only),
+	// sending the first write with the SYN once the server has given us a
+	// cookie, to save a round trip. That only helps where the client
+	// speaks first, as to a proxy or with TLS: the connect returns without
+	// waiting for the server, which a plain SSH client would then be left
+	// waiting on, for a banner it never asked for. It's quietly ignored
+	// where unsupported.
+	FastOpen bool
+}
+
+// Apply sets opts on conn's socket, which must have one.
+func (opts SocketOptions) Apply(conn net.Conn) error {
+	if opts == (SocketOptions{}) {
+		return nil
+	}
+	return ConnFD(conn, opts.apply)
+}
+
+// Control sets the options that matter from the first packet on, for
+// net.Dialer.Control. The rest are for Apply, once connected, as the
+// net package sets some of them itself on connect.
+func (opts SocketOptions) Control(network, address string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) { err = opts.control(int(fd)) }); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// Check tries the options Control sets on a throwaway socket, to find out
+// up front whether they're allowed, rather than from every connection
+// attempt failing the same way.
+func (opts SocketOptions) Check() error {
+	return opts.check()
+}
+
+// ipQoS are the names OpenSSH's IPQoS takes, and the TOS bytes they stand
+// for.
+var ipQoS = map[string]int{
+	"af11": 0x28, "af12": 0x30, "af13": 0x38,
+	"af21": 0x48, "af22": 0x50, "af23": 0x58,
+	"af31": 0x68, "af32": 0x70, "af33": 0x78,
+	"af41": 0x88, "af42": 0x90, "af43": 0x98,
+	"cs0": 0x00, "cs1": 0x20, "cs2": 0x40, "cs3": 0x60,
+	"cs4": 0x80, "cs5": 0xa0, "cs6": 0xc0, "cs7": 0xe0,
+	"ef": 0xb8, "le": 0x04,
+	"lowdelay": 0x10, "throughput": 0x08, "reliability": 0x04,
+	"none": 0,
+}
+
+// ParseIPQoS returns the TOS byte for s, given as for OpenSSH's IPQoS: a
+// DSCP name like af21 or ef, one of lowdelay, throughput or reliability, a
+// number, or none (0, leaving it be).
+func ParseIPQoS(s string) (int, error) {
+	if tos, ok := ipQoS[strings.ToLower(s)]; ok {
+		return tos, nil
+	}
+	tos, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("unknown IPQoS %q (want a name like af21 or ef, or a number up to 255)", s)
+	}
+	return int(tos), nil
+}
@@ -0,0 +1,21 @@
+//go:build aix || dragonfly || freebsd || netbsd || solaris
+
+package srvdial
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	tcpKeepIdle        = unix.TCP_KEEPIDLE
+	tcpUserTimeout     = 0 // not supported
+	soMark             = 0 // not supported
+	tcpFastOpenConnect = 0 // not supported
+)
+
+func bindToDevice(fd int, name string) error {
+	return fmt.Errorf("binding to interface %s: %w", name, errors.ErrUnsupported)
+}
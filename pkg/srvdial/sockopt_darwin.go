@@ -0,0 +1,39 @@
+package srvdial
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Darwin's TCP_KEEPALIVE is everyone else's TCP_KEEPIDLE.
+	tcpKeepIdle        = unix.TCP_KEEPALIVE
+	tcpUserTimeout     = 0 // not supported
+	soMark             = 0 // not supported
+	tcpFastOpenConnect = 0 // not supported
+)
+
+// bindToDevice binds the socket to the named interface, which macOS takes
+// by index, per address family.
+func bindToDevice(fd int, name string) error {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return fmt.Errorf("IP_BOUND_IF: %w", err)
+	}
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return err
+	}
+	if _, ok := sa.(*unix.SockaddrInet6); ok {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_BOUND_IF, ifi.Index); err != nil {
+			return fmt.Errorf("IPV6_BOUND_IF: %w", err)
+		}
+		return nil
+	}
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_BOUND_IF, ifi.Index); err != nil {
+		return fmt.Errorf("IP_BOUND_IF: %w", err)
+	}
+	return nil
+}
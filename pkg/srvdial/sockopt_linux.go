@@ -0,0 +1,28 @@
+package srvdial
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	tcpKeepIdle        = unix.TCP_KEEPIDLE
+	tcpUserTimeout     = unix.TCP_USER_TIMEOUT
+	soMark             = unix.SO_MARK
+	tcpFastOpenConnect = unix.TCP_FASTOPEN_CONNECT
+)
+
+// bindToDevice binds the socket to the named interface or VRF.
+func bindToDevice(fd int, name string) error {
+	err := unix.SetsockoptString(fd, unix.SOL_SOCKET, unix.SO_BINDTODEVICE, name)
+	if errors.Is(err, unix.ENODEV) {
+		return fmt.Errorf("SO_BINDTODEVICE: no interface %q", name)
+	} else if errors.Is(err, unix.EPERM) {
+		return fmt.Errorf("SO_BINDTODEVICE: %w (before Linux 5.7, it needs CAP_NET_RAW)", err)
+	} else if err != nil {
+		return fmt.Errorf("SO_BINDTODEVICE: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || solaris)
+
+package srvdial
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+func (opts SocketOptions) apply(fd int) error {
+	return fmt.Errorf("socket options on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
+
+func (opts SocketOptions) control(fd int) error {
+	return opts.check()
+}
+
+func (opts SocketOptions) check() error {
+	if opts.TOS == 0 && opts.Mark == 0 && opts.Interface == "" {
+		return nil
+	}
+	return opts.apply(-1)
+}
@@ -0,0 +1,122 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || solaris
+
+package srvdial
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func (opts SocketOptions) apply(fd int) error {
+	if err := opts.control(fd); err != nil {
+		return err
+	}
+	if opts.KeepAlive < 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_KEEPALIVE, 0); err != nil {
+			return fmt.Errorf("SO_KEEPALIVE: %w", err)
+		}
+	} else if opts.KeepAlive > 0 || opts.KeepAliveCount > 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_KEEPALIVE, 1); err != nil {
+			return fmt.Errorf("SO_KEEPALIVE: %w", err)
+		}
+	}
+	if opts.KeepAlive > 0 {
+		// in whole seconds, rounded up, as the kernel takes them:
+		secs := int((opts.KeepAlive + time.Second - 1) / time.Second)
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, tcpKeepIdle, secs); err != nil {
+			return fmt.Errorf("TCP_KEEPIDLE: %w", err)
+		}
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, secs); err != nil {
+			return fmt.Errorf("TCP_KEEPINTVL: %w", err)
+		}
+	}
+	if opts.KeepAliveCount > 0 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPCNT, opts.KeepAliveCount); err != nil {
+			return fmt.Errorf("TCP_KEEPCNT: %w", err)
+		}
+	}
+	if opts.UserTimeout > 0 {
+		if tcpUserTimeout == 0 {
+			return fmt.Errorf("TCP_USER_TIMEOUT: %w", errors.ErrUnsupported)
+		}
+		ms := int((opts.UserTimeout + time.Millisecond - 1) / time.Millisecond)
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, tcpUserTimeout, ms); err != nil {
+			return fmt.Errorf("TCP_USER_TIMEOUT: %w", err)
+		}
+	}
+	if opts.Nagle {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_NODELAY, 0); err != nil {
+			return fmt.Errorf("TCP_NODELAY: %w", err)
+		}
+	}
+	if opts.SendBuffer > 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_SNDBUF, opts.SendBuffer); err != nil {
+			return fmt.Errorf("SO_SNDBUF: %w", err)
+		}
+	}
+	if opts.ReceiveBuffer > 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, opts.ReceiveBuffer); err != nil {
+			return fmt.Errorf("SO_RCVBUF: %w", err)
+		}
+	}
+	return nil
+}
+
+func (opts SocketOptions) control(fd int) error {
+	if opts.TOS != 0 {
+		if err := setTOS(fd, opts.TOS); err != nil {
+			return err
+		}
+	}
+	if opts.Mark != 0 {
+		if soMark == 0 {
+			return fmt.Errorf("SO_MARK: %w", errors.ErrUnsupported)
+		}
+		err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, soMark, int(opts.Mark))
+		if errors.Is(err, unix.EPERM) {
+			return fmt.Errorf("SO_MARK: %w (it needs CAP_NET_ADMIN, or CAP_NET_RAW since Linux 5.17)", err)
+		} else if err != nil {
+			return fmt.Errorf("SO_MARK: %w", err)
+		}
+	}
+	if opts.Interface != "" {
+		if err := bindToDevice(fd, opts.Interface); err != nil {
+			return err
+		}
+	}
+	if opts.FastOpen && tcpFastOpenConnect != 0 {
+		// older kernels refuse it, and connect as usual:
+		unix.SetsockoptInt(fd, unix.IPPROTO_TCP, tcpFastOpenConnect, 1)
+	}
+	return nil
+}
+
+func (opts SocketOptions) check() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	return opts.control(fd)
+}
+
+// setTOS sets the TOS (IPv4) or traffic class (IPv6) byte of the socket.
+func setTOS(fd int, tos int) error {
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return err
+	}
+	if _, ok := sa.(*unix.SockaddrInet6); ok {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tos); err != nil {
+			return fmt.Errorf("IPV6_TCLASS: %w", err)
+		}
+		return nil
+	}
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TOS, tos); err != nil {
+		return fmt.Errorf("IP_TOS: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package srvdial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+)
+
+// socks5Dialer connects through a SOCKS5 proxy (RFC 1928), with optional
+// username/password authentication (RFC 1929). Target names are resolved
+// by the proxy.
+type socks5Dialer struct {
+	proxy   string
+	user    *url.Userinfo
+	forward ContextDialer
+}
+
+var socks5Replies = []string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+func (d *socks5Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("socks5: invalid port %q", portStr)
+	}
+
+	c, err := d.forward.DialContext(ctx, "tcp", d.proxy)
+	if err != nil {
+		return nil, err
+	}
+	restore := handshakeDeadline(ctx, c)
+	defer restore()
+
+	if err := d.handshake(c, host, uint16(port)); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("socks5 %s: %s: %w", d.proxy, address, err)
+	}
+	return c, nil
+}
+
+func (d *socks5Dialer) handshake(c net.Conn, host string, port uint16) error {
+	methods := []byte{0} // no authentication
+	if d.user != nil {
+		methods = []byte{2} // username/password
+	}
+	if _, err := c.Write(append([]byte{5, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return err
+	}
+	switch {
+	case buf[0] != 5:
+		return fmt.Errorf("unexpected version %d", buf[0])
+	case buf[1] == 0xff || buf[1] != methods[0]:
+		return errors.New("no acceptable authentication method")
+	case buf[1] == 2:
+		if err := d.authenticate(c); err != nil {
+			return err
+		}
+	}
+
+	req := []byte{5, 1, 0} // CONNECT
+	ip, err := netip.ParseAddr(host)
+	switch {
+	case err == nil && ip.Is4():
+		req = append(append(req, 1), ip.AsSlice()...)
+	case err == nil:
+		req = append(append(req, 4), ip.AsSlice()...)
+	case len(host) <= 255:
+		req = append(append(req, 3, byte(len(host))), host...)
+	default:
+		return errors.New("target name too long")
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := c.Write(req); err != nil {
+		return err
+	}
+
+	buf = make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return err
+	}
+	if rep := int(buf[1]); rep != 0 {
+		if rep < len(socks5Replies) {
+			return errors.New(socks5Replies[rep])
+		}
+		return fmt.Errorf("reply code %d", rep)
+	}
+
+	// skip the bound address:
+	var n int
+	switch buf[3] {
+	case 1:
+		n = 4
+	case 4:
+		n = 16
+	case 3:
+		if _, err := io.ReadFull(c, buf[:1]); err != nil {
+			return err
+		}
+		n = int(buf[0])
+	default:
+		return fmt.Errorf("unknown address type %d", buf[3])
+	}
+	_, err = io.ReadFull(c, make([]byte, n+2))
+	return err
+}
+
+func (d *socks5Dialer) authenticate(c net.Conn) error {
+	user := d.user.Username()
+	pass, _ := d.user.Password()
+	if len(user) > 255 || len(pass) > 255 {
+		return errors.New("username or password too long")
+	}
+
+	req := append([]byte{1, byte(len(user))}, user...)
+	req = append(append(req, byte(len(pass))), pass...)
+	if _, err := c.Write(req); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return err
+	}
+	if buf[1] != 0 {
+		return errors.New("authentication failed")
+	}
+	return nil
+}
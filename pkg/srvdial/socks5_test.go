@@ -0,0 +1,151 @@
+package srvdial
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// converse plays the server side of a handshake on c: script alternates
+// between the bytes expected from the client and the bytes to reply with.
+// The returned channel receives the first mismatch, or nil.
+func converse(c net.Conn, script ...string) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		defer c.Close()
+		for i := 0; i < len(script); i += 2 {
+			want := []byte(script[i])
+			got := make([]byte, len(want))
+			if _, err := io.ReadFull(c, got); err != nil {
+				done <- fmt.Errorf("reading step %d: %w", i/2, err)
+				return
+			}
+			if !bytes.Equal(got, want) {
+				done <- fmt.Errorf("step %d: got %q, want %q", i/2, got, want)
+				return
+			}
+			if i+1 < len(script) {
+				if _, err := io.WriteString(c, script[i+1]); err != nil {
+					done <- err
+					return
+				}
+			}
+		}
+		done <- nil
+	}()
+	return done
+}
+
+func TestSOCKS5Handshake(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    *url.Userinfo
+		host    string
+		script  []string
+		wantErr string
+	}{
+		{
+			name: "name",
+			host: "host.example.com",
+			script: []string{
+				"\x05\x01\x00", "\x05\x00",
+				"\x05\x01\x00\x03\x10host.example.com\x00\x16", "\x05\x00\x00\x01\xc0\x00\x02\x01\x04\x00",
+			},
+		},
+		{
+			name: "IPv4",
+			host: "192.0.2.1",
+			script: []string{
+				"\x05\x01\x00", "\x05\x00",
+				"\x05\x01\x00\x01\xc0\x00\x02\x01\x00\x16", "\x05\x00\x00\x03\x05proxy\x04\x00",
+			},
+		},
+		{
+			name: "IPv6",
+			host: "2001:db8::1",
+			script: []string{
+				"\x05\x01\x00", "\x05\x00",
+				"\x05\x01\x00\x04\x20\x01\x0d\xb8" + strings.Repeat("\x00", 11) + "\x01\x00\x16",
+				"\x05\x00\x00\x04" + strings.Repeat("\x00", 16) + "\x04\x00",
+			},
+		},
+		{
+			name: "password",
+			user: url.UserPassword("user", "secret"),
+			host: "host",
+			script: []string{
+				"\x05\x01\x02", "\x05\x02",
+				"\x01\x04user\x06secret", "\x01\x00",
+				"\x05\x01\x00\x03\x04host\x00\x16", "\x05\x00\x00\x01\x00\x00\x00\x00\x00\x00",
+			},
+		},
+		{
+			name:    "wrong password",
+			user:    url.UserPassword("user", "wrong"),
+			host:    "host",
+			script:  []string{"\x05\x01\x02", "\x05\x02", "\x01\x04user\x05wrong", "\x01\x01"},
+			wantErr: "authentication failed",
+		},
+		{
+			name:    "no acceptable method",
+			host:    "host",
+			script:  []string{"\x05\x01\x00", "\x05\xff"},
+			wantErr: "no acceptable authentication method",
+		},
+		{
+			name:    "not SOCKS5",
+			host:    "host",
+			script:  []string{"\x05\x01\x00", "\x04\x00"},
+			wantErr: "unexpected version 4",
+		},
+		{
+			name: "refused",
+			host: "host",
+			script: []string{
+				"\x05\x01\x00", "\x05\x00",
+				"\x05\x01\x00\x03\x04host\x00\x16", "\x05\x05\x00\x01\x00\x00\x00\x00\x00\x00",
+			},
+			wantErr: "connection refused",
+		},
+		{
+			name: "unknown reply",
+			host: "host",
+			script: []string{
+				"\x05\x01\x00", "\x05\x00",
+				"\x05\x01\x00\x03\x04host\x00\x16", "\x05\x42\x00\x01\x00\x00\x00\x00\x00\x00",
+			},
+			wantErr: "reply code 66",
+		},
+		{
+			name:    "name too long",
+			host:    strings.Repeat("a", 256),
+			script:  []string{"\x05\x01\x00", "\x05\x00"},
+			wantErr: "target name too long",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			done := converse(server, tt.script...)
+
+			d := &socks5Dialer{proxy: "proxy:1080", user: tt.user}
+			err := d.handshake(client, tt.host, 22)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("handshake: %s", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Fatalf("handshake: %v, want %q", err, tt.wantErr)
+			}
+			client.Close()
+			if err := <-done; err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrClosedPipe) {
+				t.Error(err)
+			}
+		})
+	}
+}
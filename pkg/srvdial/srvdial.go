@@ -0,0 +1,1024 @@
+// Package srvdial connects to services by their SRV records, as ssh-srv
+// does for ssh: the targets are raced in priority and weight order, each
+// connection is checked (by the server's banner, say) before it's used,
+// and the winner is returned as a plain net.Conn, or passed on as a file
+// descriptor to another process.
+package srvdial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultTimeout is how long DialSRV takes, at most, over everything.
+	DefaultTimeout = 1 * time.Minute
+
+	// DefaultStagger is the default SRVDialer.Stagger.
+	DefaultStagger = 300 * time.Millisecond
+
+	// servFailTries and servFailDelay bound the SRV lookup with
+	// ServFailRetry.
+	servFailTries = 3
+	servFailDelay = 1 * time.Second
+
+	// DefaultRetryBackoff is the default SRVDialer.RetryBackoff.
+	DefaultRetryBackoff = 1 * time.Second
+
+	// fastestWindow is how long FanoutFastest waits, after the first target
+	// is connected to, for any with a quicker connect to come through.
+	fastestWindow = 500 * time.Millisecond
+)
+
+// Race calls each func in next, waiting stagger(i) after starting next[i-1]
+// before starting next[i] (or less, if an attempt fails first), and returns
+// the first successful result. If limit is positive, at most that many
+// funcs run at once. If none succeeds, their errors are returned joined.
+//
+// Once there's a winner, or ctx is done, the funcs still running are
+// cancelled through their context, and Race waits for them to return.
+// Results that lose the race are closed, if they implement io.Closer.
+// A single func is simply called, with the whole of ctx to itself.
+func Race[T any](ctx context.Context, next []func(context.Context) (T, error), stagger func(i int) time.Duration, limit int) (T, error) {
+	return race(ctx, realClock{}, next, stagger, limit)
+}
+
+// race is Race, with the stagger timed by clock.
+func race[T any](ctx context.Context, clock Clock, next []func(context.Context) (T, error), stagger func(i int) time.Duration, limit int) (T, error) {
+	var zero T
+	if len(next) == 0 {
+		return zero, errors.New("nothing to try")
+	}
+	if len(next) == 1 {
+		// nothing to race against, so skip the machinery:
+		return next[0](ctx)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		val T
+		err error
+	}
+	// buffered, so that no worker ever blocks on sending its result:
+	results := make(chan result, len(next))
+
+	var (
+		winner  T
+		won     bool
+		errs    []error
+		running int
+		started int
+		due     = true // next[started] may start, once there's room
+		stopped bool   // ctx is done, don't start anything else
+		timer   Timer
+		timerC  <-chan time.Time
+		done    = ctx.Done()
+	)
+	stopTimer := func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		timerC = nil
+	}
+	defer stopTimer()
+
+	for {
+		for due && !won && !stopped && started < len(next) && (limit <= 0 || running < limit) {
+			n := next[started]
+			running++
+			go func() {
+				val, err := n(ctx)
+				results <- result{val, err}
+			}()
+
+			started++
+			due = false
+			if started < len(next) {
+				if wait := stagger(started); wait <= 0 {
+					// no stagger, start the next one straight away:
+					due = true
+				} else {
+					stopTimer()
+					timer = clock.NewTimer(wait)
+					timerC = timer.C()
+				}
+			}
+		}
+		if running == 0 && (won || stopped || started == len(next)) {
+			break
+		}
+
+		select {
+		case r := <-results:
+			running--
+			switch {
+			case r.err != nil:
+				errs = append(errs, r.err)
+				// failed early, move to the next without waiting for the timer:
+				stopTimer()
+				due = true
+			case won:
+				// lost the race, nobody wants it:
+				closeLoser(r.val)
+			default:
+				winner, won = r.val, true
+				cancel() // abandon the rest
+			}
+		case <-timerC:
+			// timer fired, try the next one:
+			timerC = nil
+			due = true
+		case <-done:
+			// won, or out of time; either way, nothing more to start:
+			done = nil
+			stopped = true
+			stopTimer()
+		}
+	}
+
+	if won {
+		return winner, nil
+	}
+	return zero, raceErr(ctx, errs)
+}
+
+// Fastest calls every func in next at once (at most limit at a time, if
+// positive), like Race with no stagger. But rather than taking the first
+// success, it waits up to window after it for the rest, and returns the
+// best of those that came through by less. The others are closed, if they
+// implement io.Closer.
+func Fastest[T any](ctx context.Context, next []func(context.Context) (T, error), window time.Duration, limit int, less func(a, b T) bool) (T, error) {
+	return fastest(ctx, realClock{}, next, window, limit, less)
+}
+
+// fastest is Fastest, with the window timed by clock.
+func fastest[T any](ctx context.Context, clock Clock, next []func(context.Context) (T, error), window time.Duration, limit int, less func(a, b T) bool) (T, error) {
+	var zero T
+	if len(next) == 0 {
+		return zero, errors.New("nothing to try")
+	}
+	if len(next) == 1 {
+		return next[0](ctx)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		val T
+		err error
+	}
+	results := make(chan result, len(next))
+
+	var (
+		best    T
+		found   bool
+		errs    []error
+		running int
+		started int
+		timer   Timer
+		done    = ctx.Done()
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		for done != nil && started < len(next) && (limit <= 0 || running < limit) {
+			n := next[started]
+			running++
+			started++
+			go func() {
+				val, err := n(ctx)
+				results <- result{val, err}
+			}()
+		}
+		if running == 0 && (done == nil || started == len(next)) {
+			break
+		}
+
+		select {
+		case r := <-results:
+			running--
+			switch {
+			case r.err != nil:
+				errs = append(errs, r.err)
+			case !found:
+				best, found = r.val, true
+				// once the window is up, abandon whatever's left:
+				timer = clock.AfterFunc(window, cancel)
+			case less(r.val, best):
+				closeLoser(best)
+				best = r.val
+			default:
+				closeLoser(r.val)
+			}
+		case <-done:
+			// out of time, or the window is up; nothing more to start:
+			done = nil
+		}
+	}
+
+	if found {
+		return best, nil
+	}
+	return zero, raceErr(ctx, errs)
+}
+
+// closeLoser closes a result nobody wants, if it implements io.Closer.
+func closeLoser[T any](val T) {
+	if cl, ok := any(val).(io.Closer); ok {
+		cl.Close()
+	}
+}
+
+// raceErr returns the error for a Race or Fastest that nothing succeeded,
+// given the errors from the funcs that were started.
+func raceErr(ctx context.Context, errs []error) error {
+	err := errors.Join(errs...)
+	switch {
+	case err == nil:
+		return context.Cause(ctx)
+	case ctx.Err() != nil:
+		return fmt.Errorf("%w, after:\n%w", context.Cause(ctx), err)
+	default:
+		return err
+	}
+}
+
+// Resolver looks up names, like net.Resolver, which implements it. Others
+// can answer from DNS over TLS or HTTPS, split DNS, or a fixed table.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// SRVDialer resolves SRV records and races connections to their targets.
+type SRVDialer struct {
+	// Policy is applied to the SRV answer before any target is dialed.
+	Policy Policy
+
+	// Dialer is used to connect to targets. If nil, a net.Dialer is used.
+	Dialer ContextDialer
+
+	// Resolve, if non-nil, gives the addresses to connect to for targets
+	// (and fallback hosts), keyed by TargetKey, instead of resolving them,
+	// as with curl's --resolve. They're used even with PreResolve off.
+	Resolve map[string][]net.IPAddr
+
+	// Zone is the interface to reach IPv6 link-local addresses through, as
+	// those from DNS come without one. If empty, it's the one interface
+	// with a link-local address of its own, if there's only one.
+	Zone string
+
+	// AddrPolicy, if non-nil, orders each target's addresses by the
+	// destination address selection rules of RFC 6724, with its policy
+	// table, before they're tried. If nil, the resolver's order is kept.
+	// Either way, they then alternate between IPv6 and IPv4.
+	AddrPolicy *AddrPolicy
+
+	// Resolver is used to look up SRV records, and the addresses of
+	// targets with PreResolve. If nil, net.DefaultResolver is used.
+	Resolver Resolver
+
+	// Fanout selects how connection attempts are spread over time.
+	Fanout FanoutMode
+
+	// Stagger is the delay before trying the next target of the same
+	// priority, and PriorityStagger before moving on to the next priority.
+	// If zero, Stagger defaults to DefaultStagger, and PriorityStagger to
+	// Stagger.
+	Stagger, PriorityStagger time.Duration
+
+	// Peek, if non-nil, is called on each new connection, which is only
+	// used if it returns nil.
+	Peek func(net.Conn) error
+
+	// DNSTimeout, if non-zero, bounds each DNS lookup, so that a slow
+	// resolver doesn't hold up falling back.
+	DNSTimeout time.Duration
+
+	// MaxParallel, if positive, caps how many connection attempts are in
+	// flight at once.
+	MaxParallel int
+
+	// PeekTimeout, if non-zero, gives up on a connection that hasn't passed
+	// Peek by then, such as a server that never sends a banner.
+	PeekTimeout time.Duration
+
+	// MaxTargets, if positive, only tries that many targets, from the top
+	// of the list once it's ordered.
+	MaxTargets int
+
+	// ConnectTimeout, if non-zero, bounds each connection attempt, as
+	// opposed to the overall deadline.
+	ConnectTimeout time.Duration
+
+	// PreResolve looks up the addresses of every target as soon as the SRV
+	// answer arrives, rather than when each one's turn comes, and dials
+	// those. Only set it if Dialer connects to addresses directly, not if
+	// something along the way, like a proxy or TLS, needs the name.
+	PreResolve bool
+
+	// Retries is how many more times to try the whole set of targets if
+	// none of them could be connected to, waiting RetryBackoff (doubled
+	// each time, with jitter) in between. If zero, RetryBackoff defaults
+	// to DefaultRetryBackoff; if negative, retries don't wait.
+	Retries      int
+	RetryBackoff time.Duration
+
+	// ServFail selects what happens when the SRV lookup fails for reasons
+	// other than the name not existing, such as SERVFAIL or a timeout.
+	ServFail ServFailMode
+
+	// SameZone refuses targets outside the DNS zone of the name looked up,
+	// which is found by asking Resolver for its SOA record (see LookupRaw)
+	// alongside the SRV lookup.
+	SameZone bool
+
+	// State, if non-nil, records each target's connect latency, and orders
+	// targets by it. It's saved at the end of each DialSRVContext.
+	State *State
+
+	// Cooldown is how long after failing a target is tried only after all
+	// the others, if State is set.
+	Cooldown time.Duration
+
+	// Prefer lists DNS suffixes whose targets are tried first, ahead of
+	// SRV priorities, such as those of the local site.
+	Prefer []string
+
+	// Seed, if non-zero, makes the random order of targets of the same
+	// priority (by weight) the same every time. Otherwise a seed is picked
+	// at random, and logged.
+	Seed uint64
+
+	// Sticky tries the target last connected to for the same name first,
+	// if State is set.
+	Sticky bool
+
+	// Verify, if non-nil, is called for each target whose connection
+	// passed Peek, with that connection, which is only used if it returns
+	// nil.
+	Verify func(context.Context, *net.SRV, net.Conn) error
+
+	// Track, if non-nil, is called as each connection attempt starts, with
+	// the target's host:port, and the func it returns with the outcome.
+	Track func(target string) (done func(error))
+
+	// Logf, if non-nil, is given the progress messages that otherwise go
+	// to the standard log package. Peek and Verify log for themselves
+	// (see SSHPeek.Logf).
+	Logf func(format string, v ...any)
+
+	// Tracer, if non-nil, records spans for the SRV lookup, and each
+	// target's address lookup, dial and peek, under any span in the
+	// context given to DialSRVContext.
+	Tracer trace.Tracer
+
+	// Clock, if non-nil, times the stagger, retries, peek timeout and
+	// connect latency, instead of the real time, such as for tests.
+	Clock Clock
+}
+
+// Stats counts resources used by every SRVDialer in the process.
+var Stats struct {
+	DNSLookups atomic.Int64 // queries we asked the resolver to make
+	Sockets    atomic.Int64 // outgoing connection attempts
+}
+
+// track calls Track, if set.
+func (sd *SRVDialer) track(target string) (done func(error)) {
+	if sd.Track == nil {
+		return func(error) {}
+	}
+	return sd.Track(target)
+}
+
+// logf calls Logf, or log.Printf if it's not set.
+func (sd *SRVDialer) logf(format string, v ...any) {
+	if sd.Logf == nil {
+		log.Printf(format, v...)
+		return
+	}
+	sd.Logf(format, v...)
+}
+
+// FanoutMode selects how connection attempts to SRV targets are started.
+type FanoutMode int
+
+const (
+	FanoutStagger    FanoutMode = iota // start the next attempt after a delay
+	FanoutAll                          // start every attempt at once
+	FanoutSequential                   // start the next attempt only after a failure
+	FanoutFastest                      // start every attempt at once, keep the quickest to connect
+)
+
+var fanoutModes = []string{
+	FanoutStagger:    "stagger",
+	FanoutAll:        "all",
+	FanoutSequential: "sequential",
+	FanoutFastest:    "fastest",
+}
+
+func (m FanoutMode) String() string {
+	if m < 0 || int(m) >= len(fanoutModes) {
+		return fmt.Sprintf("FanoutMode(%d)", m)
+	}
+	return fanoutModes[m]
+}
+
+func (m *FanoutMode) Set(s string) error {
+	for i, name := range fanoutModes {
+		if s == name {
+			*m = FanoutMode(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown fanout mode %q (want one of %s)", s, strings.Join(fanoutModes, ", "))
+}
+
+// ServFailMode selects how resolver failures are treated, as opposed to
+// the name having no SRV records, which always falls back.
+type ServFailMode int
+
+const (
+	ServFailFallback ServFailMode = iota // fall back, as for no records
+	ServFailRetry                        // retry the lookup, then give up
+	ServFailFail                         // give up straight away
+)
+
+var servFailModes = []string{
+	ServFailFallback: "fallback",
+	ServFailRetry:    "retry",
+	ServFailFail:     "fail",
+}
+
+func (m ServFailMode) String() string {
+	if m < 0 || int(m) >= len(servFailModes) {
+		return fmt.Sprintf("ServFailMode(%d)", m)
+	}
+	return servFailModes[m]
+}
+
+func (m *ServFailMode) Set(s string) error {
+	for i, name := range servFailModes {
+		if s == name {
+			*m = ServFailMode(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown servfail mode %q (want one of %s)", s, strings.Join(servFailModes, ", "))
+}
+
+// stagger returns the delay before dialing addrs[i], for Race. With nil
+// addrs, every attempt is treated as being of the same priority.
+func (sd *SRVDialer) stagger(addrs []*net.SRV) func(i int) time.Duration {
+	within := sd.Stagger
+	if within == 0 {
+		within = DefaultStagger
+	}
+	across := sd.PriorityStagger
+	if across == 0 {
+		across = within
+	}
+
+	return func(i int) time.Duration {
+		switch {
+		case sd.Fanout == FanoutAll, sd.Fanout == FanoutFastest:
+			return 0
+		case sd.Fanout == FanoutSequential:
+			return math.MaxInt64
+		case addrs != nil && addrs[i].Priority != addrs[i-1].Priority:
+			return across
+		default:
+			return within
+		}
+	}
+}
+
+// LookupSRV looks up SRV records, retrying failures other than the name not
+// existing if ServFail says so. Policy's srv rules stand in for them, or
+// with srv-add, are added to them.
+func (sd *SRVDialer) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	cname, addrs, static, err := sd.lookupSRV(ctx, service, proto, name)
+	return cname, append(addrs, static...), err
+}
+
+// lookupSRV is LookupSRV, keeping the targets from srv rules apart from
+// those from DNS.
+func (sd *SRVDialer) lookupSRV(ctx context.Context, service, proto, name string) (cname string, addrs, static []*net.SRV, err error) {
+	static, add, r := sd.Policy.static(service, proto, name)
+	if r != nil && !add {
+		sd.logf("Policy (%s): using %d static SRV targets instead of DNS", r.source(), len(static))
+		return "_" + service + "._" + proto + "." + normalizeTarget(name) + ".", nil, static, nil
+	}
+
+	cname, addrs, err = sd.lookupDNSSRV(ctx, service, proto, name)
+	if r == nil {
+		return cname, addrs, nil, err
+	}
+	if err != nil {
+		if !IsNotFound(err) {
+			sd.logf("SRV lookup failed, using the static targets alone: %s", err)
+		}
+		cname = "_" + service + "._" + proto + "." + normalizeTarget(name) + "."
+	}
+	sd.logf("Policy (%s): adding %d static SRV targets", r.source(), len(static))
+	return cname, addrs, static, nil
+}
+
+// lookupDNSSRV is LookupSRV, without the Policy.
+func (sd *SRVDialer) lookupDNSSRV(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error) {
+	ctx, span := sd.startSpan(ctx, "srv lookup", attrService.String(service), attrProto.String(proto), attrName.String(name))
+	defer func() {
+		span.SetAttributes(attrRecords.Int(len(addrs)))
+		if IsNotFound(err) {
+			// an answer, as far as tracing is concerned:
+			endSpan(ctx, span, nil)
+			return
+		}
+		endSpan(ctx, span, err)
+	}()
+	for try := 1; ; try++ {
+		Stats.DNSLookups.Add(1)
+		lctx, cancel := sd.lookupContext(ctx)
+		cname, addrs, err := sd.resolver().LookupSRV(lctx, service, proto, name)
+		cancel()
+		if err == nil || IsNotFound(err) || sd.ServFail != ServFailRetry || try == servFailTries {
+			return cname, addrs, err
+		}
+		sd.logf("SRV lookup failed (try %d of %d): %s", try, servFailTries, err)
+		t := sd.clock().NewTimer(servFailDelay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return "", nil, err
+		case <-t.C():
+		}
+	}
+}
+
+func (sd *SRVDialer) resolver() Resolver {
+	if sd.Resolver == nil {
+		return net.DefaultResolver
+	}
+	return sd.Resolver
+}
+
+// lookupContext returns ctx bounded by DNSTimeout, if set.
+func (sd *SRVDialer) lookupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if sd.DNSTimeout > 0 {
+		return context.WithTimeout(ctx, sd.DNSTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// IsNotFound reports whether a lookup failed because the name doesn't
+// exist or has no records of the type (NXDOMAIN or NODATA), rather than
+// the resolver failing.
+func IsNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// DialContext connects to addr with Dialer, giving up after ConnectTimeout.
+func (sd *SRVDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := sd.Dialer
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	if sd.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sd.ConnectTimeout)
+		defer cancel()
+	}
+	Stats.Sockets.Add(1)
+	return d.DialContext(ctx, network, addr)
+}
+
+// dialIPs connects to the first of ips that answers on port, trying them
+// one after another, as Dialer would for a name.
+func (sd *SRVDialer) dialIPs(ctx context.Context, network string, ips []net.IPAddr, port uint16) (net.Conn, error) {
+	ips, errs := sd.orderAddrs(ips)
+	for _, ip := range ips {
+		conn, err := sd.DialContext(ctx, network, net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no addresses")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// orderAddrs returns the order to try ips in: by AddrPolicy's RFC 6724
+// rules, if set, and alternating between IPv6 and IPv4. IPv6 link-local
+// addresses get their zone first, and those that can't are left out,
+// with an error each.
+func (sd *SRVDialer) orderAddrs(ips []net.IPAddr) ([]net.IPAddr, []error) {
+	var zoned []net.IPAddr
+	var errs []error
+	for _, ip := range ips {
+		ip, err := sd.withZone(ip)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		zoned = append(zoned, ip)
+	}
+	if sd.AddrPolicy != nil && len(zoned) > 1 {
+		sorted := sd.AddrPolicy.SortAddrs(zoned)
+		if !slices.EqualFunc(sorted, zoned, func(a, b net.IPAddr) bool { return a.String() == b.String() }) {
+			sd.logf("Address order (RFC 6724): %s", joinIPs(sorted))
+		}
+		zoned = sorted
+	}
+	return interleaveFamilies(zoned), errs
+}
+
+// withZone returns ip with sd's Zone, if it's an IPv6 link-local address
+// without one, which can't be connected to otherwise.
+func (sd *SRVDialer) withZone(ip net.IPAddr) (net.IPAddr, error) {
+	if ip.Zone != "" || ip.IP.To4() != nil || !ip.IP.IsLinkLocalUnicast() {
+		return ip, nil
+	}
+	ip.Zone = sd.Zone
+	if ip.Zone == "" {
+		var err error
+		if ip.Zone, err = linkLocalZone(); err != nil {
+			return ip, fmt.Errorf("%s is link-local, and %w (see -interface)", ip.IP, err)
+		}
+	}
+	return ip, nil
+}
+
+// linkLocalZone returns the one interface that's up with an IPv6
+// link-local address, which is where a link-local address from DNS must
+// be, if there's no other.
+func linkLocalZone() (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok && ipn.IP.To4() == nil && ipn.IP.IsLinkLocalUnicast() {
+				names = append(names, ifi.Name)
+				break
+			}
+		}
+	}
+	switch len(names) {
+	case 0:
+		return "", errors.New("no interface has a link-local address")
+	case 1:
+		return names[0], nil
+	}
+	return "", fmt.Errorf("it could be on any of %s", strings.Join(names, ", "))
+}
+
+// closeWhenDone closes conn once ctx is done, such as when another attempt
+// has won the race, which also unblocks a pending Peek. The returned func
+// keeps conn open for the winner, reporting false if it's too late.
+func closeWhenDone(ctx context.Context, conn net.Conn) (stop func() bool) {
+	return context.AfterFunc(ctx, func() { shutdown(conn) })
+}
+
+// shutdown closes conn, shutting it down first to also wake a -peek-cmd
+// blocked on a dup of the fd.
+func shutdown(conn net.Conn) {
+	if cr, ok := conn.(interface{ CloseRead() error }); ok {
+		cr.CloseRead()
+	}
+	conn.Close()
+}
+
+// tryPeek runs Peek, if set, on a new connection, closing it on failure.
+// Connections that can't be peeked at as they are come back wrapped in a
+// peekConn, replaying what was peeked, to be used in their place.
+func (sd *SRVDialer) tryPeek(ctx context.Context, conn net.Conn) (_ net.Conn, err error) {
+	if sd.Peek == nil {
+		return conn, nil
+	}
+	ctx, span := sd.startSpan(ctx, "peek")
+	defer func() { endSpan(ctx, span, err) }()
+
+	conn = peekable(conn)
+	var timer Timer
+	if sd.PeekTimeout > 0 {
+		timer = sd.clock().AfterFunc(sd.PeekTimeout, func() { shutdown(conn) })
+	}
+	err = sd.Peek(conn)
+	if timer != nil && !timer.Stop() {
+		err = fmt.Errorf("no banner within %s (see -peek-timeout)", sd.PeekTimeout)
+	}
+	if err != nil {
+		sd.logf("%s: peek: %s", conn.RemoteAddr(), err)
+		conn.Close()
+		return nil, fmt.Errorf("%w: %w", ErrPeekMismatch, err)
+	}
+	sd.logf("Peek succeeded for %s", conn.RemoteAddr())
+	return conn, nil
+}
+
+// Conn is a connection to an SRV target.
+type Conn struct {
+	net.Conn
+	Target  *net.SRV      // the SRV target connected to
+	Connect time.Duration // how long the TCP connect took
+}
+
+// DialSRV connects to the best target of the SRV records for
+// _service._proto.name that answers, trying them in order (see Fanout) and
+// giving up after DefaultTimeout. It returns an error wrapping ErrSRVLookup
+// if there are no records to try (see ServFail), for falling back to name,
+// and otherwise one of the others in errors.go.
+func (sd *SRVDialer) DialSRV(service, proto, name string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeoutCause(context.Background(), DefaultTimeout, ErrTimeout)
+	defer cancel()
+
+	sc, err := sd.DialSRVContext(ctx, service, proto, name)
+	return sc.Conn, err
+}
+
+// DialSRVContext is like DialSRV, but also returns which target was
+// connected to, and gives up when ctx is done.
+func (sd *SRVDialer) DialSRVContext(ctx context.Context, service, proto, name string) (Conn, error) {
+	cname, addrs, err := sd.Targets(ctx, service, proto, name)
+	if err != nil {
+		return Conn{}, err
+	}
+
+	var tryAddr []func(context.Context) (Conn, error)
+
+	for _, addr := range addrs {
+		sd.logf("Resolved (prio %d, weight %d) %s:%d",
+			addr.Priority, addr.Weight, addr.Target, addr.Port)
+
+		var resolved func() ([]net.IPAddr, error)
+		if sd.PreResolve && !IsOnion(addr.Target) {
+			// so that later targets don't wait for DNS on top of the stagger:
+			resolved = sd.LookupAhead(ctx, addr.Target)
+		}
+		tryAddr = append(tryAddr, func(ctx context.Context) (Conn, error) {
+			done := sd.track(TargetKey(addr))
+			sc, err := sd.tryTarget(ctx, proto, addr, resolved)
+			done(err)
+			if err != nil {
+				if ctx.Err() == nil {
+					// failed of its own accord, rather than losing the race:
+					sd.State.recordFailure(addr)
+				}
+				return sc, fmt.Errorf("%s:%d: %w", addr.Target, addr.Port, err)
+			}
+			sd.State.recordSuccess(addr)
+			return sc, nil
+		})
+	}
+
+	defer func() {
+		if err := sd.State.Save(); err != nil {
+			sd.logf("Saving state: %s", err)
+		}
+	}()
+	backoff := sd.RetryBackoff
+	if backoff == 0 {
+		backoff = DefaultRetryBackoff
+	}
+	sc, err := retry(ctx, sd.clock(), sd.Retries, backoff, sd.logf, func() (Conn, error) {
+		if sd.Fanout != FanoutFastest {
+			return race(ctx, sd.clock(), tryAddr, sd.stagger(addrs), sd.MaxParallel)
+		}
+		sc, err := fastest(ctx, sd.clock(), tryAddr, fastestWindow, sd.MaxParallel, func(a, b Conn) bool {
+			return a.Connect < b.Connect
+		})
+		if err == nil {
+			sd.logf("Fastest: %s:%d, connected in %s",
+				sc.Target.Target, sc.Target.Port, sc.Connect.Round(time.Microsecond))
+		}
+		return sc, err
+	})
+	switch {
+	case err == nil:
+		sd.State.recordLastGood(cname, sc.Target)
+	case ctx.Err() != nil:
+		err = timedOut(ctx, err)
+	default:
+		err = fmt.Errorf("%w:\n%w", ErrAllTargetsFailed, err)
+	}
+	return sc, err
+}
+
+// Targets returns the SRV targets for _service._proto.name that
+// DialSRVContext would try, in the order it would try them: those left
+// after CheckSRV, SameZone and Policy, and ordered by priority and weight,
+// State, Prefer and MaxTargets. Its errors are those of DialSRVContext for
+// when there's nothing to try.
+func (sd *SRVDialer) Targets(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error) {
+	var zone func() string
+	if sd.SameZone {
+		zone = sd.zoneAhead(ctx, name)
+	}
+	cname, addrs, static, err := sd.lookupSRV(ctx, service, proto, name)
+	switch {
+	case err == nil:
+	case IsNotFound(err):
+		return "", nil, fmt.Errorf("%w: %w: %w", ErrSRVLookup, ErrNoSRVRecords, err)
+	case sd.ServFail == ServFailFallback:
+		sd.logf("SRV lookup failed, falling back anyway (see -servfail): %s", err)
+		return "", nil, fmt.Errorf("%w: %w", ErrSRVLookup, err)
+	default:
+		return "", nil, fmt.Errorf("SRV lookup failed, not falling back (see -servfail): %w", err)
+	}
+	if len(addrs) > 0 || static == nil {
+		sd.logf("%d SRV records found for %s", len(addrs), cname)
+	}
+
+	// A target of "." says the service is decidedly not available (RFC 2782):
+	if len(addrs) == 1 && addrs[0].Target == "." && static == nil {
+		return "", nil, fmt.Errorf("%s says the service is not available there", cname)
+	}
+	addrs, problems := CheckSRV(append(addrs, static...))
+	for _, problem := range problems {
+		sd.logf("%s: %s", cname, problem)
+	}
+	if len(addrs) == 0 {
+		return "", nil, fmt.Errorf("no usable SRV targets for %s", cname)
+	}
+
+	if sd.SameZone {
+		// static targets, and those with addresses from Resolve, are local
+		// configuration, trusted as rewrites are:
+		isStatic := func(addr *net.SRV) bool {
+			_, pinned := sd.ResolveOverride(addr.Target, strconv.Itoa(int(addr.Port)))
+			return pinned || slices.Contains(static, addr)
+		}
+		if fromDNS := slices.DeleteFunc(slices.Clone(addrs), isStatic); len(fromDNS) > 0 {
+			kept := sd.sameZone(ctx, cname, name, zone, fromDNS)
+			addrs = slices.DeleteFunc(addrs, func(addr *net.SRV) bool {
+				return !isStatic(addr) && !slices.Contains(kept, addr)
+			})
+		}
+		if len(addrs) == 0 {
+			return "", nil, fmt.Errorf("all SRV targets for %s are outside its zone", cname)
+		}
+	}
+
+	seed := sd.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	if shuffleSRV(addrs, rand.New(rand.NewPCG(seed, 0))) {
+		// the order is logged as each target is tried:
+		sd.logf("Ordered by weight with seed %d (see -seed)", seed)
+	}
+
+	if addrs = sd.Policy.apply(addrs, sd.logf); len(addrs) == 0 {
+		return "", nil, fmt.Errorf("all SRV targets for %s blocked by policy", cname)
+	}
+	var first string
+	if sd.Sticky {
+		first = sd.State.lastGood(cname)
+	}
+	addrs = sd.State.order(addrs, sd.Cooldown, first, sd.logf)
+	addrs = prefer(addrs, sd.Prefer, sd.logf)
+	if sd.MaxTargets > 0 && len(addrs) > sd.MaxTargets {
+		sd.logf("Only trying the first %d of %d targets (see -max-targets)", sd.MaxTargets, len(addrs))
+		addrs = addrs[:sd.MaxTargets]
+	}
+	return cname, addrs, nil
+}
+
+// DialTarget connects to one SRV target, then peeks and verifies it, as
+// DialSRV would. With PreResolve, its addresses are looked up with
+// Resolver, too.
+func (sd *SRVDialer) DialTarget(ctx context.Context, proto string, addr *net.SRV) (Conn, error) {
+	var resolved func() ([]net.IPAddr, error)
+	if sd.PreResolve && !IsOnion(addr.Target) {
+		resolved = sd.LookupAhead(ctx, addr.Target)
+	}
+	return sd.tryTarget(ctx, proto, addr, resolved)
+}
+
+// tryTarget connects to one SRV target, then peeks and verifies it. If
+// resolved is nil, the dialer is left to resolve the target itself.
+func (sd *SRVDialer) tryTarget(ctx context.Context, proto string, addr *net.SRV, resolved func() ([]net.IPAddr, error)) (_ Conn, err error) {
+	ctx, span := sd.startSpan(ctx, "dial target", targetAttrs(addr)...)
+	defer func() { endSpan(ctx, span, err) }()
+
+	sd.logf("Trying to connect: %s:%d", addr.Target, addr.Port)
+
+	if ips, ok := sd.ResolveOverride(addr.Target, strconv.Itoa(int(addr.Port))); ok {
+		sd.logf("Using %s for %s (see -resolve)", joinIPs(ips), TargetKey(addr))
+		resolved = func() ([]net.IPAddr, error) { return ips, nil }
+	}
+
+	var conn net.Conn
+	start := sd.clock().Now()
+	if resolved == nil {
+		Stats.DNSLookups.Add(1) // the dialer resolves the target itself
+		conn, err = sd.DialContext(ctx, proto, net.JoinHostPort(addr.Target, strconv.Itoa(int(addr.Port))))
+	} else {
+		var ips []net.IPAddr
+		if ips, err = resolved(); err == nil {
+			conn, err = sd.dialIPs(ctx, proto, ips, addr.Port)
+		} else {
+			sd.logf("%s doesn't resolve: %s", addr.Target, err)
+		}
+	}
+	if err != nil {
+		return Conn{}, err
+	}
+	connect := sd.clock().Now().Sub(start)
+	sd.State.recordLatency(addr, connect)
+	sd.logf("Connected to %s", conn.RemoteAddr())
+	stop := closeWhenDone(ctx, conn)
+
+	if conn, err = sd.tryPeek(ctx, conn); err != nil {
+		return Conn{}, err
+	}
+	if sd.Verify != nil {
+		if err := sd.Verify(ctx, addr, conn); err != nil {
+			sd.logf("%s:%d: %s", addr.Target, addr.Port, err)
+			conn.Close()
+			return Conn{}, err
+		}
+	}
+
+	if !stop() {
+		return Conn{}, ctx.Err()
+	}
+	return Conn{conn, addr, connect}, nil
+}
+
+// ResolveOverride returns the addresses Resolve gives for host on port, if
+// any.
+func (sd *SRVDialer) ResolveOverride(host, port string) ([]net.IPAddr, bool) {
+	ips, ok := sd.Resolve[normalizeTarget(host)+":"+port]
+	return ips, ok
+}
+
+// joinIPs lists ips for logging.
+func joinIPs(ips []net.IPAddr) string {
+	s := make([]string, len(ips))
+	for i, ip := range ips {
+		s[i] = ip.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+// retry calls f until it succeeds, up to 1+retries times, with jittered
+// exponential backoff starting at backoff (timed by clock) in between, saying
+// so to logf. With backoff <= 0, it doesn't wait. It gives up early when ctx
+// is done.
+func retry[T any](ctx context.Context, clock Clock, retries int, backoff time.Duration, logf func(string, ...any), f func() (T, error)) (T, error) {
+	for try := 0; ; try++ {
+		val, err := f()
+		if err == nil || try >= retries || ctx.Err() != nil {
+			return val, err
+		}
+
+		// between half and one and a half times the backoff:
+		var wait time.Duration
+		if backoff > 0 {
+			wait = backoff/2 + rand.N(backoff)
+		}
+		logf("No targets connected (try %d of %d), retrying in %s: %s", try+1, retries+1, wait.Round(time.Millisecond), err)
+		t := clock.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return val, err
+		case <-t.C():
+		}
+		backoff *= 2
+	}
+}
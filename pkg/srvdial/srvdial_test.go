@@ -0,0 +1,467 @@
+package srvdial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeResolver answers SRV and TXT queries from tables, and SOA queries
+// (for SameZone) with zone, for names ending in it.
+type fakeResolver struct {
+	srv       map[string][]*net.SRV // by "_service._proto.name", without the trailing dot
+	err       error                 // returned for names not in srv; NXDOMAIN if nil
+	zone      string
+	txt       map[string][]string // TXT records by name, without the trailing dot
+	ad        bool                // sets the AD bit on SRV answers
+	validates bool                // says the AD bit can be believed, as a validating resolver would
+
+	lookups atomic.Int32 // SRV lookups asked for
+}
+
+var (
+	errNXDomain = &net.DNSError{Err: "no such host", IsNotFound: true}
+	errServFail = &net.DNSError{Err: "server misbehaving", IsTemporary: true}
+)
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.lookups.Add(1)
+	key := "_" + service + "._" + proto + "." + normalizeTarget(name)
+	addrs, ok := r.srv[key]
+	switch {
+	case ok:
+		var clone []*net.SRV
+		for _, addr := range addrs {
+			c := *addr
+			clone = append(clone, &c)
+		}
+		return key + ".", clone, nil
+	case r.err != nil:
+		return "", nil, r.err
+	}
+	return "", nil, errNXDomain
+}
+
+// LookupIPAddr answers for any host but those under .invalid.
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if strings.HasSuffix(normalizeTarget(host), ".invalid") {
+		return nil, errNXDomain
+	}
+	return []net.IPAddr{{IP: net.IPv4(192, 0, 2, 1)}}, nil
+}
+
+func (r *fakeResolver) LookupRaw(ctx context.Context, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	m := &dnsmessage.Message{}
+	switch qtype {
+	case dnsmessage.TypeSOA:
+		if n := normalizeTarget(name); r.zone == "" || n != r.zone && !strings.HasSuffix(n, "."+r.zone) {
+			m.RCode = dnsmessage.RCodeNameError
+			return m, nil
+		}
+		m.Authorities = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(r.zone + "."), Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET},
+			Body:   &dnsmessage.SOAResource{},
+		}}
+	case dnsmessage.TypeSRV:
+		m.AuthenticData = r.ad
+	case dnsmessage.TypeTXT:
+		txts, ok := r.txt[normalizeTarget(name)]
+		if !ok {
+			m.RCode = dnsmessage.RCodeNameError
+		}
+		for _, txt := range txts {
+			m.Answers = append(m.Answers, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name + "."), Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET},
+				Body:   &dnsmessage.TXTResource{TXT: []string{txt}},
+			})
+		}
+	}
+	return m, nil
+}
+
+func (r *fakeResolver) ValidatesDNSSEC() bool {
+	return r.validates
+}
+
+// refusingDialer fails every connection, noting the addresses asked for,
+// so that DialSRVContext tries every target it would.
+type refusingDialer struct {
+	mu     sync.Mutex
+	dialed []string
+}
+
+func (d *refusingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dialed = append(d.dialed, addr)
+	return nil, errors.New("connection refused")
+}
+
+func srvs(targets ...string) []*net.SRV {
+	var addrs []*net.SRV
+	for _, t := range targets {
+		host, port, _ := net.SplitHostPort(t)
+		n, _ := strconv.ParseUint(port, 10, 16)
+		addrs = append(addrs, &net.SRV{Target: host + ".", Port: uint16(n), Weight: 1})
+	}
+	return addrs
+}
+
+func mustPolicy(t *testing.T, lines ...string) Policy {
+	t.Helper()
+	var p Policy
+	for i, line := range lines {
+		r, err := parsePolicyRule(strings.Fields(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Line = i + 1
+		p = append(p, r)
+	}
+	return p
+}
+
+func TestDialSRVContext(t *testing.T) {
+	const name = "_ssh._tcp.host.example.com"
+	tests := []struct {
+		name     string
+		srv      []*net.SRV
+		err      error // from the resolver, if srv is nil
+		servFail ServFailMode
+		policy   []string
+		sameZone bool
+		zone     string // for SameZone
+		ad       bool
+		validate bool // whether the resolver says its AD bit can be believed
+		mdns     bool // whether it's behind an MDNSResolver
+
+		dialed    []string // every address tried, in any order
+		noLookup  bool     // whether DNS isn't asked at all
+		fallback  bool     // whether the error wraps ErrSRVLookup
+		noRecords bool     // whether it wraps ErrNoSRVRecords, too
+	}{
+		{
+			name:   "records",
+			srv:    srvs("a.example.com:22", "b.example.com:2222"),
+			dialed: []string{"a.example.com.:22", "b.example.com.:2222"},
+		},
+		{
+			name:      "nxdomain",
+			err:       errNXDomain,
+			fallback:  true,
+			noRecords: true,
+		},
+		{
+			name:      "nxdomain with servfail fail",
+			err:       errNXDomain,
+			servFail:  ServFailFail,
+			fallback:  true,
+			noRecords: true,
+		},
+		{
+			name:     "servfail falls back",
+			err:      errServFail,
+			servFail: ServFailFallback,
+			fallback: true,
+		},
+		{
+			name:     "servfail fails",
+			err:      errServFail,
+			servFail: ServFailFail,
+		},
+		{
+			name: "dot target",
+			srv:  []*net.SRV{{Target: "."}},
+		},
+		{
+			name:   "dot target alongside others",
+			srv:    append(srvs("a.example.com:22"), &net.SRV{Target: "."}),
+			dialed: []string{"a.example.com.:22"},
+		},
+		{
+			name:   "checked",
+			srv:    srvs("a.example.com:22", "a.example.com:22", "b.example.com:0", "192.0.2.9:22"),
+			dialed: []string{"a.example.com.:22", "192.0.2.9.:22"},
+		},
+		{
+			name: "nothing usable",
+			srv:  srvs("a.example.com:0"),
+		},
+		{
+			name:     "srv rule",
+			srv:      srvs("a.example.com:22"),
+			policy:   []string{"srv host.example.com static.example.net:22", "srv host.example.com static2.example.net:22"},
+			dialed:   []string{"static.example.net:22", "static2.example.net:22"},
+			noLookup: true,
+		},
+		{
+			name:   "srv rule for another host",
+			srv:    srvs("a.example.com:22"),
+			policy: []string{"srv other.example.com static.example.net:22"},
+			dialed: []string{"a.example.com.:22"},
+		},
+		{
+			name:   "srv-add rule",
+			srv:    srvs("a.example.com:22"),
+			policy: []string{"srv-add host.example.com static.example.net:22"},
+			dialed: []string{"a.example.com.:22", "static.example.net:22"},
+		},
+		{
+			name:   "srv-add rule without records",
+			err:    errNXDomain,
+			policy: []string{"srv-add host.example.com static.example.net:22"},
+			dialed: []string{"static.example.net:22"},
+		},
+		{
+			name:   "srv-add rule with servfail",
+			err:    errServFail,
+			policy: []string{"srv-add host.example.com static.example.net:22"},
+			dialed: []string{"static.example.net:22"},
+		},
+		{
+			name:   "blocked",
+			srv:    srvs("a.example.com:22", "b.example.com:22"),
+			policy: []string{"block b.*"},
+			dialed: []string{"a.example.com.:22"},
+		},
+		{
+			name:     "same zone",
+			srv:      srvs("a.example.com:22", "evil.example.net:22", "b.host.example.com:22"),
+			sameZone: true,
+			zone:     "example.com",
+			dialed:   []string{"a.example.com.:22", "b.host.example.com.:22"},
+		},
+		{
+			name:     "same zone, validated",
+			srv:      srvs("a.example.com:22", "evil.example.net:22"),
+			sameZone: true,
+			zone:     "example.com",
+			ad:       true,
+			validate: true,
+			dialed:   []string{"a.example.com.:22", "evil.example.net.:22"},
+		},
+		{
+			name:     "same zone, AD from a resolver that doesn't validate",
+			srv:      srvs("a.example.com:22", "evil.example.net:22"),
+			sameZone: true,
+			zone:     "example.com",
+			ad:       true,
+			dialed:   []string{"a.example.com.:22"},
+		},
+		{
+			name:     "same zone, AD through mDNS",
+			srv:      srvs("a.example.com:22", "evil.example.net:22"),
+			sameZone: true,
+			zone:     "example.com",
+			ad:       true,
+			mdns:     true,
+			dialed:   []string{"a.example.com.:22"},
+		},
+		{
+			name:     "same zone, validated through mDNS",
+			srv:      srvs("a.example.com:22", "evil.example.net:22"),
+			sameZone: true,
+			zone:     "example.com",
+			ad:       true,
+			validate: true,
+			mdns:     true,
+			dialed:   []string{"a.example.com.:22", "evil.example.net.:22"},
+		},
+		{
+			name:     "same zone, no SOA",
+			srv:      srvs("a.example.com:22", "a.host.example.com:22"),
+			sameZone: true,
+			// only host.example.com itself is taken to be in the zone:
+			dialed: []string{"a.host.example.com.:22"},
+		},
+		{
+			name:     "same zone, top-level domain",
+			srv:      srvs("a.example.com:22", "evil.example.net:22", "evil.com:22"),
+			sameZone: true,
+			zone:     "com",
+		},
+		{
+			name:     "same zone, all outside",
+			srv:      srvs("evil.example.net:22"),
+			sameZone: true,
+			zone:     "example.com",
+		},
+		{
+			name:     "same zone, static targets",
+			srv:      srvs("evil.example.net:22"),
+			policy:   []string{"srv-add host.example.com static.example.net:22"},
+			sameZone: true,
+			zone:     "example.com",
+			dialed:   []string{"static.example.net:22"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &fakeResolver{err: tt.err, zone: tt.zone, ad: tt.ad, validates: tt.validate}
+			if tt.srv != nil {
+				r.srv = map[string][]*net.SRV{name: tt.srv}
+			}
+			var resolver Resolver = r
+			if tt.mdns {
+				resolver = MDNSResolver{Next: r}
+			}
+			d := &refusingDialer{}
+			sd := &SRVDialer{
+				Resolver: resolver,
+				Dialer:   d,
+				Fanout:   FanoutAll,
+				ServFail: tt.servFail,
+				SameZone: tt.sameZone,
+				Policy:   mustPolicy(t, tt.policy...),
+				Clock:    newFakeClock(),
+				Logf:     t.Logf,
+			}
+
+			_, err := sd.DialSRVContext(context.Background(), "ssh", "tcp", "host.example.com")
+			if err == nil {
+				t.Fatal("connected through a dialer that refuses everything")
+			}
+			if got := errors.Is(err, ErrSRVLookup); got != tt.fallback {
+				t.Errorf("errors.Is(%q, ErrSRVLookup) = %v, want %v", err, got, tt.fallback)
+			}
+			if got := errors.Is(err, ErrNoSRVRecords); got != tt.noRecords {
+				t.Errorf("errors.Is(%q, ErrNoSRVRecords) = %v, want %v", err, got, tt.noRecords)
+			}
+			if got, want := errors.Is(err, ErrAllTargetsFailed), len(tt.dialed) > 0; got != want {
+				t.Errorf("errors.Is(%q, ErrAllTargetsFailed) = %v, want %v", err, got, want)
+			}
+
+			slices.Sort(d.dialed)
+			want := slices.Clone(tt.dialed)
+			slices.Sort(want)
+			if !slices.Equal(d.dialed, want) {
+				t.Errorf("dialed %q, want %q", d.dialed, want)
+			}
+
+			lookups := int32(1)
+			if tt.noLookup {
+				lookups = 0
+			}
+			if got := r.lookups.Load(); got != lookups {
+				t.Errorf("%d SRV lookups, want %d", got, lookups)
+			}
+		})
+	}
+}
+
+func TestDialSRVContextServFailRetry(t *testing.T) {
+	r := &fakeResolver{err: errServFail}
+	clock := newFakeClock()
+	sd := &SRVDialer{Resolver: r, ServFail: ServFailRetry, Clock: clock, Logf: t.Logf}
+
+	res := make(chan error, 1)
+	go func() {
+		_, err := sd.DialSRVContext(context.Background(), "ssh", "tcp", "host.example.com")
+		res <- err
+	}()
+	for range servFailTries - 1 {
+		clock.WaitTimers(1)
+		clock.Advance(servFailDelay)
+	}
+
+	var err error
+	select {
+	case err = <-res:
+	case <-time.After(5 * time.Second):
+		t.Fatal("DialSRVContext never returned")
+	}
+	if errors.Is(err, ErrSRVLookup) {
+		t.Errorf("error %q wraps ErrSRVLookup, so would fall back", err)
+	}
+	if !errors.Is(err, errServFail) {
+		t.Errorf("error %q doesn't wrap the resolver's", err)
+	}
+	if got := r.lookups.Load(); got != servFailTries {
+		t.Errorf("%d SRV lookups, want %d", got, servFailTries)
+	}
+	var want []time.Duration
+	for range servFailTries - 1 {
+		want = append(want, servFailDelay)
+	}
+	if !slices.Equal(clock.Waits(), want) {
+		t.Errorf("waited %v between lookups, want %v", clock.Waits(), want)
+	}
+}
+
+func TestTargets(t *testing.T) {
+	r := &fakeResolver{srv: map[string][]*net.SRV{
+		"_ssh._tcp.host.example.com": {
+			{Target: "b.example.com.", Port: 22, Priority: 10, Weight: 1},
+			{Target: "a.example.com.", Port: 22, Priority: 0, Weight: 1},
+			{Target: "c.example.com.", Port: 22, Priority: 20, Weight: 1},
+			{Target: "a.example.com.", Port: 22, Priority: 0, Weight: 1},
+		},
+	}}
+	sd := &SRVDialer{
+		Resolver:   r,
+		Policy:     mustPolicy(t, "rewrite c.example.com d.example.com:2222"),
+		MaxTargets: 2,
+		Prefer:     []string{"b.example.com"},
+		Logf:       t.Logf,
+	}
+	cname, addrs, err := sd.Targets(context.Background(), "ssh", "tcp", "host.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cname != "_ssh._tcp.host.example.com." {
+		t.Errorf("cname = %q", cname)
+	}
+	var got []string
+	for _, addr := range addrs {
+		got = append(got, TargetKey(addr))
+	}
+	// by priority, but b is preferred, and only the first two are kept:
+	want := []string{"b.example.com:22", "a.example.com:22"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Targets = %q, want %q", got, want)
+	}
+
+	sd.MaxTargets = 0
+	if _, addrs, _ = sd.Targets(context.Background(), "ssh", "tcp", "host.example.com"); TargetKey(addrs[2]) != "d.example.com:2222" {
+		t.Errorf("last target = %s, want the rewritten d.example.com:2222", TargetKey(addrs[2]))
+	}
+}
+
+func TestModeString(t *testing.T) {
+	for _, name := range fanoutModes {
+		var m FanoutMode
+		if err := m.Set(name); err != nil || m.String() != name {
+			t.Errorf("FanoutMode %q: Set then String = %q, %v", name, m, err)
+		}
+	}
+	for _, name := range servFailModes {
+		var m ServFailMode
+		if err := m.Set(name); err != nil || m.String() != name {
+			t.Errorf("ServFailMode %q: Set then String = %q, %v", name, m, err)
+		}
+	}
+	tests := []struct {
+		m    fmt.Stringer
+		want string
+	}{
+		{FanoutMode(-1), "FanoutMode(-1)"},
+		{FanoutMode(len(fanoutModes)), fmt.Sprintf("FanoutMode(%d)", len(fanoutModes))},
+		{ServFailMode(-1), "ServFailMode(-1)"},
+		{ServFailMode(len(servFailModes)), fmt.Sprintf("ServFailMode(%d)", len(servFailModes))},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package srvdial
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// typeSSHFP is the SSHFP resource record type (RFC 4255).
+const typeSSHFP dnsmessage.Type = 44
+
+// sshfpAlgorithms maps host key types to SSHFP algorithm numbers.
+var sshfpAlgorithms = map[string]uint8{
+	"ssh-rsa":             1,
+	"ssh-dss":             2,
+	"ecdsa-sha2-nistp256": 3,
+	"ecdsa-sha2-nistp384": 3,
+	"ecdsa-sha2-nistp521": 3,
+	"ssh-ed25519":         4,
+	"ssh-ed448":           6,
+}
+
+// An sshfp is one SSHFP record.
+type sshfp struct {
+	Algorithm   uint8
+	Type        uint8 // 1 for SHA-1, 2 for SHA-256
+	Fingerprint []byte
+}
+
+func (r sshfp) String() string {
+	return fmt.Sprintf("%d %d %x", r.Algorithm, r.Type, r.Fingerprint)
+}
+
+// matches reports whether r is a fingerprint of the host key blob of type
+// keyType.
+func (r sshfp) matches(keyType string, blob []byte) bool {
+	if sshfpAlgorithms[keyType] != r.Algorithm {
+		return false
+	}
+	switch r.Type {
+	case 1:
+		sum := sha1.Sum(blob)
+		return bytes.Equal(r.Fingerprint, sum[:])
+	case 2:
+		sum := sha256.Sum256(blob)
+		return bytes.Equal(r.Fingerprint, sum[:])
+	}
+	return false
+}
+
+// lookupSSHFP returns host's SSHFP records, which are only of any use if
+// they're DNSSEC validated, as whoever could forge the SRV answer could
+// forge them too. So, as for VerifyHostKeyDNS in ssh, they're refused
+// otherwise.
+func lookupSSHFP(ctx context.Context, host string) ([]sshfp, error) {
+	m, validated, err := lookupValidated(ctx, nil, host, typeSSHFP)
+	if err != nil {
+		return nil, err
+	}
+	if m.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("lookup %s SSHFP: %s", host, m.RCode)
+	}
+	if !validated {
+		return nil, fmt.Errorf("lookup %s SSHFP: the answer isn't DNSSEC validated", host)
+	}
+	var records []sshfp
+	for _, rr := range m.Answers {
+		u, ok := rr.Body.(*dnsmessage.UnknownResource)
+		if !ok || rr.Header.Type != typeSSHFP || len(u.Data) < 3 {
+			continue
+		}
+		records = append(records, sshfp{u.Data[0], u.Data[1], u.Data[2:]})
+	}
+	return records, nil
+}
+
+// VerifySSHFP checks the host keys of an SRV target against its SSHFP
+// records, refusing targets without any, or whose records aren't DNSSEC
+// validated. The keys are fetched with ssh-keyscan, so this works without
+// taking over ssh's key exchange, on a connection of its own to the same
+// address as conn, which must be a direct TCP connection to the target (not
+// through a proxy, TLS or Tor). That's still a separate connection, so it
+// only shows that the server at that address has a published key; it's
+// worth having ssh check the key it gets too, with VerifyHostKeyDNS.
+func VerifySSHFP(ctx context.Context, target *net.SRV, conn net.Conn) error {
+	host := strings.TrimSuffix(target.Target, ".")
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || IsOnion(host) {
+		return fmt.Errorf("sshfp: can't check %s, as it's not connected to directly", host)
+	}
+	records, err := lookupSSHFP(ctx, host)
+	if err != nil {
+		return fmt.Errorf("sshfp: %w", err)
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("sshfp: no SSHFP records for %s", host)
+	}
+	for _, r := range records {
+		log.Printf("SSHFP %s: %s", host, r)
+	}
+
+	timeout := "5"
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = strconv.Itoa(max(1, int(time.Until(deadline).Seconds())))
+	}
+	// ssh-keyscan takes the address without brackets, zone and all:
+	ip := addr.IP.String()
+	if addr.Zone != "" {
+		ip += "%" + addr.Zone
+	}
+	out, err := exec.CommandContext(ctx, "ssh-keyscan", "-T", timeout,
+		"-p", strconv.Itoa(addr.Port), ip).Output()
+	if err != nil {
+		// not %w, so that ssh-keyscan's exit status isn't taken for that
+		// of a command the caller ran
+		return fmt.Errorf("sshfp: ssh-keyscan: %v", err)
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		// HOST KEYTYPE BASE64
+		fields := strings.Fields(s.Text())
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		blob, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil {
+			continue
+		}
+		for _, r := range records {
+			if r.matches(fields[1], blob) {
+				sum := sha256.Sum256(blob)
+				log.Printf("SSHFP verified %s key for %s at %s: %s", fields[1], host, ip, hex.EncodeToString(sum[:]))
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("sshfp: no host key of %s at %s matches its SSHFP records", host, addr)
+}
@@ -0,0 +1,336 @@
+package srvdial
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// latencyWeight is how much a new connect latency sample counts for in
+	// a target's moving average, against what's already known.
+	latencyWeight = 0.25
+
+	// latencyMaxAge is how long a target's latency is remembered without
+	// another sample.
+	latencyMaxAge = 7 * 24 * time.Hour
+
+	// DefaultCooldown is the default SRVDialer.Cooldown for ssh-srv.
+	DefaultCooldown = 1 * time.Minute
+)
+
+// State is what's been learned about SRV targets in earlier runs, kept in a
+// small JSON file between them.
+//
+// Several ssh-srv processes may share the file. Save merges in only the
+// entries this one changed, so at worst a concurrent update to the same
+// target is lost.
+type State struct {
+	mu         sync.Mutex
+	path       string
+	targets    map[string]*targetState
+	hosts      map[string]*hostState
+	dirty      map[string]bool
+	dirtyHosts map[string]bool
+}
+
+type targetState struct {
+	Latency time.Duration `json:"latency"` // moving average of connect latency
+	Updated time.Time     `json:"updated"` // when Latency was last sampled
+	Failed  time.Time     `json:"failed"`  // when it last failed, if since it last worked
+}
+
+// hostState is kept per SRV name, such as _ssh._tcp.example.com.
+type hostState struct {
+	LastGood string    `json:"last_good"` // TargetKey of the last target connected to
+	Updated  time.Time `json:"updated"`
+}
+
+type stateJSON struct {
+	Targets map[string]*targetState `json:"targets"`
+	Hosts   map[string]*hostState   `json:"hosts"`
+}
+
+// DefaultStatePath is where ssh-srv keeps its state file unless told
+// otherwise, or "" if there's no cache directory.
+func DefaultStatePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "ssh-srv", "state.json")
+}
+
+// LoadState reads the state file at path, which needn't exist yet.
+func LoadState(path string) (*State, error) {
+	f, err := readState(path)
+	if err != nil {
+		return nil, err
+	}
+	return &State{
+		path:       path,
+		targets:    f.Targets,
+		hosts:      f.Hosts,
+		dirty:      map[string]bool{},
+		dirtyHosts: map[string]bool{},
+	}, nil
+}
+
+func readState(path string) (*stateJSON, error) {
+	f := &stateJSON{}
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(b, f); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if f.Targets == nil {
+		f.Targets = map[string]*targetState{}
+	}
+	if f.Hosts == nil {
+		f.Hosts = map[string]*hostState{}
+	}
+	for key, ts := range f.Targets {
+		if ts == nil || time.Since(ts.Updated) > latencyMaxAge && time.Since(ts.Failed) > latencyMaxAge {
+			delete(f.Targets, key)
+		}
+	}
+	for key, hs := range f.Hosts {
+		if hs == nil || time.Since(hs.Updated) > latencyMaxAge {
+			delete(f.Hosts, key)
+		}
+	}
+	return f, nil
+}
+
+// Save writes the entries changed since LoadState back to the file, on top
+// of whatever other processes have written to it in the meantime.
+func (st *State) Save() error {
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if len(st.dirty) == 0 && len(st.dirtyHosts) == 0 {
+		return nil
+	}
+
+	cur, err := readState(st.path)
+	if err != nil {
+		// unreadable, so there's nothing worth keeping:
+		cur = &stateJSON{Targets: map[string]*targetState{}, Hosts: map[string]*hostState{}}
+	}
+	for key := range st.dirty {
+		cur.Targets[key] = st.targets[key]
+	}
+	for key := range st.dirtyHosts {
+		cur.Hosts[key] = st.hosts[key]
+	}
+	b, err := json.MarshalIndent(cur, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(st.path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), st.path); err != nil {
+		return err
+	}
+	clear(st.dirty)
+	clear(st.dirtyHosts)
+	return nil
+}
+
+// TargetKey names target in the state file.
+func TargetKey(target *net.SRV) string {
+	return fmt.Sprintf("%s:%d", normalizeTarget(target.Target), target.Port)
+}
+
+// recordLatency folds a connect latency sample for target into what's known.
+func (st *State) recordLatency(target *net.SRV, d time.Duration) {
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	ts := st.update(target)
+	if ts.Latency == 0 {
+		ts.Latency = d
+	} else {
+		ts.Latency = time.Duration(latencyWeight*float64(d) + (1-latencyWeight)*float64(ts.Latency))
+	}
+	ts.Updated = time.Now()
+}
+
+// recordFailure notes that target just failed, such as by refusing the
+// connection or not passing Peek.
+func (st *State) recordFailure(target *net.SRV) {
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.update(target).Failed = time.Now()
+}
+
+// recordSuccess forgets that target failed before.
+func (st *State) recordSuccess(target *net.SRV) {
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if ts := st.targets[TargetKey(target)]; ts != nil && !ts.Failed.IsZero() {
+		st.update(target).Failed = time.Time{}
+	}
+}
+
+// recordLastGood notes that target was the one connected to for name.
+func (st *State) recordLastGood(name string, target *net.SRV) {
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	key := normalizeTarget(name)
+	st.hosts[key] = &hostState{LastGood: TargetKey(target), Updated: time.Now()}
+	st.dirtyHosts[key] = true
+}
+
+// lastGood returns the TargetKey of the target last connected to for name,
+// or "" if none is known.
+func (st *State) lastGood(name string) string {
+	if st == nil {
+		return ""
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if hs := st.hosts[normalizeTarget(name)]; hs != nil {
+		return hs.LastGood
+	}
+	return ""
+}
+
+// update returns a copy of target's entry to change, marking it for Save. The
+// caller must hold mu.
+func (st *State) update(target *net.SRV) *targetState {
+	key := TargetKey(target)
+	ts := &targetState{}
+	if old := st.targets[key]; old != nil {
+		*ts = *old
+	}
+	st.targets[key] = ts
+	st.dirty[key] = true
+	return ts
+}
+
+// Latency returns target's moving average connect latency, if it's been
+// connected to lately.
+func (st *State) Latency(target *net.SRV) (time.Duration, bool) {
+	if st == nil {
+		return 0, false
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	ts := st.targets[TargetKey(target)]
+	if ts == nil || ts.Latency == 0 {
+		return 0, false
+	}
+	return ts.Latency, true
+}
+
+// failedWithin reports when target last failed, if that was less than
+// cooldown ago.
+func (st *State) failedWithin(target *net.SRV, cooldown time.Duration) (time.Time, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	ts := st.targets[TargetKey(target)]
+	if ts == nil || ts.Failed.IsZero() || time.Since(ts.Failed) >= cooldown {
+		return time.Time{}, false
+	}
+	return ts.Failed, true
+}
+
+// order sorts addrs within each priority by their known connect latency,
+// fastest first, followed in SRV order by those nothing is known about.
+// Targets that failed less than cooldown ago go after all the others, but
+// before that, the one whose TargetKey is first (if any) goes ahead of them.
+// What it did is said to logf. The input slice is not modified.
+func (st *State) order(addrs []*net.SRV, cooldown time.Duration, first string, logf func(string, ...any)) []*net.SRV {
+	if st == nil {
+		return addrs
+	}
+	failed := map[*net.SRV]bool{}
+	for _, addr := range addrs {
+		if t, ok := st.failedWithin(addr, cooldown); ok {
+			logf("Trying %s:%d last, it failed %s ago (see -cooldown)",
+				addr.Target, addr.Port, time.Since(t).Round(time.Second))
+			failed[addr] = true
+		}
+	}
+
+	out := slices.Clone(addrs)
+	slices.SortStableFunc(out, func(a, b *net.SRV) int {
+		if failed[a] != failed[b] {
+			if failed[a] {
+				return 1
+			}
+			return -1
+		}
+		if fa, fb := TargetKey(a) == first, TargetKey(b) == first; fa != fb {
+			if fa {
+				return -1
+			}
+			return 1
+		}
+		if a.Priority != b.Priority {
+			return cmp.Compare(a.Priority, b.Priority)
+		}
+		la, oka := st.Latency(a)
+		lb, okb := st.Latency(b)
+		switch {
+		case oka && okb:
+			return cmp.Compare(la, lb)
+		case oka:
+			return -1
+		case okb:
+			return 1
+		default:
+			return 0
+		}
+	})
+	switch {
+	case len(out) > 0 && !failed[out[0]] && TargetKey(out[0]) == first:
+		logf("Trying %s:%d first, it worked last time (see -sticky)", out[0].Target, out[0].Port)
+	case len(failed) == 0 && !slices.Equal(out, addrs):
+		logf("Trying the fastest known targets first (see -state)")
+	}
+	return out
+}
@@ -0,0 +1,201 @@
+package srvdial
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func targetKeys(addrs []*net.SRV) []string {
+	var keys []string
+	for _, addr := range addrs {
+		keys = append(keys, TargetKey(addr))
+	}
+	return keys
+}
+
+func TestStateSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ssh-srv", "state.json")
+	a := &net.SRV{Target: "a.example.com.", Port: 22}
+	b := &net.SRV{Target: "b.example.com.", Port: 22}
+
+	st, err := LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.recordLatency(a, 100*time.Millisecond)
+	st.recordLatency(a, 200*time.Millisecond)
+	if err := st.Save(); err != nil {
+		t.Fatal(err)
+	}
+	// another process saving later keeps what this one learned:
+	other.recordLatency(b, 50*time.Millisecond)
+	if err := other.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err = LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := st.Latency(a); !ok || d != 125*time.Millisecond {
+		t.Errorf("latency of a = %v, %v; want 125ms", d, ok)
+	}
+	if d, ok := st.Latency(b); !ok || d != 50*time.Millisecond {
+		t.Errorf("latency of b = %v, %v; want 50ms", d, ok)
+	}
+	if _, ok := st.Latency(&net.SRV{Target: "c.example.com.", Port: 22}); ok {
+		t.Error("latency of c is known")
+	}
+}
+
+func TestStateExpiry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	old := time.Now().Add(-latencyMaxAge - time.Hour).Format(time.RFC3339)
+	recent := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	data := `{"targets": {
+		"old.example.com:22": {"latency": 1000000, "updated": "` + old + `"},
+		"recent.example.com:22": {"latency": 1000000, "updated": "` + recent + `"}
+	}}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	st, err := LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := st.Latency(&net.SRV{Target: "old.example.com", Port: 22}); ok {
+		t.Error("an entry older than latencyMaxAge was kept")
+	}
+	if _, ok := st.Latency(&net.SRV{Target: "Recent.Example.com.", Port: 22}); !ok {
+		t.Error("a recent entry was dropped")
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadState(path); err == nil {
+		t.Error("LoadState of a corrupt file succeeded")
+	}
+}
+
+func TestStateOrder(t *testing.T) {
+	st, err := LoadState(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := []*net.SRV{
+		{Target: "a.example.com.", Port: 22, Priority: 10},
+		{Target: "b.example.com.", Port: 22, Priority: 10},
+		{Target: "c.example.com.", Port: 22, Priority: 10},
+		{Target: "d.example.com.", Port: 22, Priority: 20},
+		{Target: "e.example.com.", Port: 22, Priority: 20},
+	}
+	st.recordLatency(addrs[2], 10*time.Millisecond)
+	st.recordLatency(addrs[1], 30*time.Millisecond)
+	st.recordLatency(addrs[4], 1*time.Millisecond)
+
+	got := targetKeys(st.order(addrs, time.Minute, "", t.Logf))
+	want := []string{"c.example.com:22", "b.example.com:22", "a.example.com:22", "e.example.com:22", "d.example.com:22"}
+	if !slices.Equal(got, want) {
+		t.Errorf("order = %q, want %q", got, want)
+	}
+	if addrs[0].Target != "a.example.com." {
+		t.Error("order modified its input")
+	}
+
+	var none *State
+	if got := none.order(addrs, time.Minute, "", t.Logf); &got[0] != &addrs[0] {
+		t.Error("a nil State reordered the targets")
+	}
+}
+
+func TestStateCooldown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	st, err := LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := []*net.SRV{
+		{Target: "a.example.com.", Port: 22, Priority: 10},
+		{Target: "b.example.com.", Port: 22, Priority: 20},
+		{Target: "c.example.com.", Port: 22, Priority: 30},
+	}
+	st.recordLatency(addrs[0], 10*time.Millisecond)
+	st.recordFailure(addrs[0])
+	st.recordFailure(addrs[1])
+	if err := st.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if st, err = LoadState(path); err != nil {
+		t.Fatal(err)
+	}
+
+	got := targetKeys(st.order(addrs, time.Minute, "", t.Logf))
+	want := []string{"c.example.com:22", "a.example.com:22", "b.example.com:22"}
+	if !slices.Equal(got, want) {
+		t.Errorf("order after failures = %q, want %q", got, want)
+	}
+	if got := targetKeys(st.order(addrs, 0, "", t.Logf)); !slices.Equal(got, targetKeys(addrs)) {
+		t.Errorf("order without a cooldown = %q", got)
+	}
+
+	st.recordSuccess(addrs[0])
+	got = targetKeys(st.order(addrs, time.Minute, "", t.Logf))
+	want = []string{"a.example.com:22", "c.example.com:22", "b.example.com:22"}
+	if !slices.Equal(got, want) {
+		t.Errorf("order after a success = %q, want %q", got, want)
+	}
+	if d, ok := st.Latency(addrs[0]); !ok || d != 10*time.Millisecond {
+		t.Errorf("recordSuccess changed the latency to %v, %v", d, ok)
+	}
+}
+
+func TestStateSticky(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	st, err := LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := []*net.SRV{
+		{Target: "a.example.com.", Port: 22, Priority: 10},
+		{Target: "b.example.com.", Port: 22, Priority: 10},
+		{Target: "c.example.com.", Port: 22, Priority: 20},
+	}
+	const name = "_ssh._tcp.example.com."
+	if got := st.lastGood(name); got != "" {
+		t.Errorf("lastGood before any connection = %q", got)
+	}
+	st.recordLastGood(name, addrs[2])
+	if err := st.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if st, err = LoadState(path); err != nil {
+		t.Fatal(err)
+	}
+	first := st.lastGood("_SSH._tcp.Example.com")
+	if first != "c.example.com:22" {
+		t.Fatalf("lastGood = %q, want c.example.com:22", first)
+	}
+
+	got := targetKeys(st.order(addrs, time.Minute, first, t.Logf))
+	want := []string{"c.example.com:22", "a.example.com:22", "b.example.com:22"}
+	if !slices.Equal(got, want) {
+		t.Errorf("order = %q, want %q", got, want)
+	}
+
+	// but not ahead of the cooldown:
+	st.recordFailure(addrs[2])
+	got = targetKeys(st.order(addrs, time.Minute, first, t.Logf))
+	want = []string{"a.example.com:22", "b.example.com:22", "c.example.com:22"}
+	if !slices.Equal(got, want) {
+		t.Errorf("order after it failed = %q, want %q", got, want)
+	}
+}
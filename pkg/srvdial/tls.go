@@ -0,0 +1,69 @@
+package srvdial
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// tlsDialer wraps connections in TLS before SSH is spoken over them, for
+// servers hidden behind stunnel or haproxy on port 443. The result can't be
+// passed to ssh as a descriptor, so it's only useful in relay mode.
+type tlsDialer struct {
+	config *tls.Config
+	next   ContextDialer
+}
+
+// NewTLSDialer returns a dialer wrapping connections made with next in TLS,
+// using serverName for SNI and
+// verification (or the target's name, if empty), the client certificate in
+// certFile and keyFile if given, and the extra CAs in caFile if given.
+func NewTLSDialer(serverName, certFile, keyFile, caFile string, next ContextDialer) (ContextDialer, error) {
+	config := &tls.Config{ServerName: serverName}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", caFile)
+		}
+		config.RootCAs = pool
+	}
+	return &tlsDialer{config: config, next: next}, nil
+}
+
+func (d *tlsDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	c, err := d.next.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	config := d.config
+	if config.ServerName == "" {
+		host, _, _ := net.SplitHostPort(address)
+		config = config.Clone()
+		config.ServerName = strings.TrimSuffix(host, ".")
+	}
+	tc := tls.Client(c, config)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return newPeekConn(tc), nil
+}
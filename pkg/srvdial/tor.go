@@ -0,0 +1,42 @@
+package srvdial
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// DefaultTorSOCKS is where a local Tor daemon listens for SOCKS by default.
+const DefaultTorSOCKS = "127.0.0.1:9050"
+
+// IsOnion reports whether host is a Tor hidden service name, which must
+// never be looked up in DNS.
+func IsOnion(host string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(strings.ToLower(host), "."), ".onion")
+}
+
+// onionDialer connects to .onion addresses through Tor, and to everything
+// else through next.
+type onionDialer struct {
+	tor  ContextDialer
+	next ContextDialer
+}
+
+// NewOnionDialer returns a dialer connecting to .onion addresses through the
+// Tor SOCKS port at torSOCKS, reached with forward, and to everything else
+// with next.
+func NewOnionDialer(torSOCKS string, forward, next ContextDialer) ContextDialer {
+	return &onionDialer{
+		tor:  &socks5Dialer{proxy: torSOCKS, forward: forward},
+		next: next,
+	}
+}
+
+func (d *onionDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || !IsOnion(host) {
+		return d.next.DialContext(ctx, network, address)
+	}
+	// Tor doesn't accept fully-qualified onion names:
+	return d.tor.DialContext(ctx, network, net.JoinHostPort(strings.TrimSuffix(host, "."), port))
+}
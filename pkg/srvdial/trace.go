@@ -0,0 +1,60 @@
+package srvdial
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Span attributes, besides the errors recorded on failed spans.
+const (
+	attrService  = attribute.Key("srv.service")
+	attrProto    = attribute.Key("srv.proto")
+	attrName     = attribute.Key("srv.name")
+	attrRecords  = attribute.Key("srv.records")
+	attrTarget   = attribute.Key("srv.target")
+	attrPort     = attribute.Key("srv.port")
+	attrPriority = attribute.Key("srv.priority")
+	attrWeight   = attribute.Key("srv.weight")
+	attrAddrs    = attribute.Key("srv.addresses")
+	attrOutcome  = attribute.Key("srv.outcome")
+)
+
+// startSpan starts a span from Tracer, if set, as a child of any in ctx.
+func (sd *SRVDialer) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if sd.Tracer == nil {
+		return ctx, noop.Span{}
+	}
+	return sd.Tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// targetAttrs describes an SRV target, for its spans.
+func targetAttrs(addr *net.SRV) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attrTarget.String(addr.Target),
+		attrPort.Int(int(addr.Port)),
+		attrPriority.Int(int(addr.Priority)),
+		attrWeight.Int(int(addr.Weight)),
+	}
+}
+
+// endSpan ends span with an outcome of "ok", "cancelled" (such as for
+// losing the race) or "failed", recording err in the last case.
+func endSpan(ctx context.Context, span trace.Span, err error) {
+	switch {
+	case err == nil:
+		span.SetAttributes(attrOutcome.String("ok"))
+	case ctx.Err() != nil && !errors.Is(err, ErrPeekMismatch):
+		span.SetAttributes(attrOutcome.String("cancelled"))
+	default:
+		span.SetAttributes(attrOutcome.String("failed"))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
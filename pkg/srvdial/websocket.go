@@ -0,0 +1,299 @@
+package srvdial
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// wsGUID is appended to the handshake key to compute the accept value.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC11B65"
+
+// WebSocket opcodes.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// DialWebSocket tunnels to host through the WebSocket at rawURL, with %h
+// and %p replaced by host and port. The special URL "dns" uses the one
+// advertised for host in DNS instead (see lookupWebSocket). The tunnel is
+// peeked like any other connection.
+func (sd *SRVDialer) DialWebSocket(ctx context.Context, rawURL, host, port string) (net.Conn, error) {
+	if rawURL == "dns" {
+		var err error
+		if rawURL, err = sd.lookupWebSocket(ctx, host); err != nil {
+			return nil, err
+		}
+	} else {
+		rawURL = strings.NewReplacer("%h", url.PathEscape(host), "%p", port).Replace(rawURL)
+	}
+
+	d := sd.Dialer
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	c, err := dialWebSocket(ctx, d, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	sd.logf("WebSocket tunnel open to %s", redactURL(rawURL))
+
+	return sd.tryPeek(ctx, newPeekConn(c))
+}
+
+func redactURL(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return u.Redacted()
+	}
+	return rawURL
+}
+
+// lookupWebSocket finds the WebSocket URL advertised for host in a TXT
+// record at _ssh-ws.HOST, looked up with Resolver (see LookupTXT).
+func (sd *SRVDialer) lookupWebSocket(ctx context.Context, host string) (string, error) {
+	Stats.DNSLookups.Add(1)
+	lctx, cancel := sd.lookupContext(ctx)
+	defer cancel()
+	txts, err := LookupTXT(lctx, sd.Resolver, "_ssh-ws."+host)
+	if err != nil {
+		return "", err
+	}
+	for _, txt := range txts {
+		if strings.HasPrefix(txt, "ws://") || strings.HasPrefix(txt, "wss://") {
+			return txt, nil
+		}
+	}
+	return "", fmt.Errorf("no WebSocket URL in TXT records for _ssh-ws.%s", host)
+}
+
+// dialWebSocket connects to the WebSocket at rawURL through d, returning a
+// conn that carries the byte stream in binary messages.
+func dialWebSocket(ctx context.Context, d ContextDialer, rawURL string) (net.Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	var addr string
+	switch u.Scheme {
+	case "ws":
+		addr = hostPortDefault(u, "80")
+	case "wss":
+		addr = hostPortDefault(u, "443")
+	default:
+		return nil, fmt.Errorf("unsupported WebSocket scheme %q", u.Scheme)
+	}
+
+	Stats.Sockets.Add(1)
+	c, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "wss" {
+		tc := tls.Client(c, &tls.Config{ServerName: u.Hostname()})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			c.Close()
+			return nil, err
+		}
+		c = tc
+	}
+
+	restore := handshakeDeadline(ctx, c)
+	defer restore()
+	wc, err := wsHandshake(c, u)
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("websocket %s: %w", u.Redacted(), err)
+	}
+	return wc, nil
+}
+
+func wsHandshake(c net.Conn, u *url.URL) (*wsConn, error) {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := &http.Request{
+		Method: "GET",
+		URL:    u,
+		Host:   u.Host,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		req.SetBasicAuth(u.User.Username(), pass)
+	}
+	if err := req.Write(c); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("server said %q", resp.Status)
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		return nil, errors.New("bad Sec-WebSocket-Accept")
+	}
+	return &wsConn{Conn: c, br: br}, nil
+}
+
+// wsConn is a client WebSocket connection, read and written as a stream.
+type wsConn struct {
+	net.Conn
+	br *bufio.Reader
+
+	remaining int64  // unread payload bytes in the current frame
+	mask      []byte // masking key of the current frame, if any
+	maskPos   int    // position in mask
+
+	wmu sync.Mutex // serializes frame writes
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+	for c.remaining == 0 {
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(b)) > c.remaining {
+		b = b[:c.remaining]
+	}
+	n, err := c.br.Read(b)
+	c.remaining -= int64(n)
+	for i := range b[:n] {
+		if c.mask != nil {
+			b[i] ^= c.mask[c.maskPos%4]
+			c.maskPos++
+		}
+	}
+	return n, err
+}
+
+// nextFrame reads frame headers until one carrying data, answering control
+// frames along the way.
+func (c *wsConn) nextFrame() error {
+	for {
+		var hdr [2]byte
+		if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+			return err
+		}
+		opcode := hdr[0] & 0x0f
+		length := int64(hdr[1] & 0x7f)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+				return err
+			}
+			length = int64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+				return err
+			}
+			length = int64(binary.BigEndian.Uint64(ext[:]) & (1<<63 - 1))
+		}
+		var mask []byte
+		if hdr[1]&0x80 != 0 {
+			mask = make([]byte, 4)
+			if _, err := io.ReadFull(c.br, mask); err != nil {
+				return err
+			}
+		}
+
+		switch {
+		case opcode == wsContinuation, opcode == wsText, opcode == wsBinary:
+			c.remaining, c.mask, c.maskPos = length, mask, 0
+			return nil
+		case opcode&0x8 == 0:
+			return fmt.Errorf("websocket: unknown opcode %#x", opcode)
+		case length > 125 || hdr[0]&0x80 == 0:
+			// control frames carry at most 125 bytes, unfragmented
+			// (RFC 6455, section 5.5), so this is all that's ever buffered:
+			return fmt.Errorf("websocket: invalid control frame (opcode %#x, %d bytes)", opcode, length)
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return err
+		}
+		for i := range payload {
+			if mask != nil {
+				payload[i] ^= mask[i%4]
+			}
+		}
+		switch opcode {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return err
+			}
+		case wsClose:
+			c.writeFrame(wsClose, payload)
+			return io.EOF
+		}
+	}
+}
+
+func (c *wsConn) Write(b []byte) (int, error) {
+	if err := c.writeFrame(wsBinary, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// CloseWrite sends a close frame. WebSocket has no half-close, so the
+// server will close its side too once it has answered.
+func (c *wsConn) CloseWrite() error {
+	return c.writeFrame(wsClose, []byte{0x03, 0xe8}) // 1000: normal closure
+}
+
+// writeFrame sends payload in a single masked frame, as clients must.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, 0x80|126), uint16(n))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, 0x80|127), uint64(n))
+	}
+	mask := make([]byte, 4)
+	rand.Read(mask)
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.Conn.Write(frame)
+	return err
+}
@@ -0,0 +1,294 @@
+package srvdial
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// wsFrame returns a frame as a server would send it, masked only if mask
+// is given.
+func wsFrame(fin bool, opcode byte, mask []byte, payload string) []byte {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+	var m byte
+	if mask != nil {
+		m = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, m|byte(n))
+	case n <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, m|126), uint16(n))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, m|127), uint64(n))
+	}
+	frame = append(frame, mask...)
+	for i := range len(payload) {
+		b := payload[i]
+		if mask != nil {
+			b ^= mask[i%4]
+		}
+		frame = append(frame, b)
+	}
+	return frame
+}
+
+// readClientFrame reads a frame sent by wsConn, which must be final and
+// masked, and returns its opcode and unmasked payload.
+func readClientFrame(r io.Reader) (byte, string, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, "", err
+	}
+	if hdr[0]&0x80 == 0 || hdr[1]&0x80 == 0 {
+		return 0, "", fmt.Errorf("frame header %x is not final and masked", hdr)
+	}
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, "", err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, "", err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	buf := make([]byte, 4+n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, "", err
+	}
+	mask, payload := buf[:4], buf[4:]
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return hdr[0] & 0x0f, string(payload), nil
+}
+
+func TestWSHandshake(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		auth    string // user:password the server sees
+		status  string
+		accept  func(key string) string
+		wantErr string
+	}{
+		{name: "ok", url: "ws://relay.example.com/ssh/host", auth: ":"},
+		{name: "basic auth", url: "ws://user:secret@relay.example.com/ssh/host", auth: "user:secret"},
+		{
+			name:    "refused",
+			url:     "ws://relay.example.com/ssh/host",
+			auth:    ":",
+			status:  "403 Forbidden",
+			wantErr: `server said "403 Forbidden"`,
+		},
+		{
+			name:    "bad accept",
+			url:     "ws://relay.example.com/ssh/host",
+			auth:    ":",
+			accept:  func(key string) string { return key },
+			wantErr: "bad Sec-WebSocket-Accept",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client, server := net.Pipe()
+			defer client.Close()
+
+			got := make(chan string, 1)
+			go func() {
+				defer server.Close()
+				br := bufio.NewReader(server)
+				req, err := http.ReadRequest(br)
+				if err != nil {
+					got <- err.Error()
+					return
+				}
+				user, pass, _ := req.BasicAuth()
+				got <- fmt.Sprintf("%s %s %s %s %s:%s", req.Method, req.Host, req.URL.Path,
+					req.Header.Get("Upgrade"), user, pass)
+
+				key := req.Header.Get("Sec-WebSocket-Key")
+				sum := sha1.Sum([]byte(key + wsGUID))
+				accept := base64.StdEncoding.EncodeToString(sum[:])
+				if tt.accept != nil {
+					accept = tt.accept(key)
+				}
+				status := tt.status
+				if status == "" {
+					status = "101 Switching Protocols"
+				}
+				// the banner comes in the same write, so it must not be
+				// lost to the response reader's buffer:
+				resp := fmt.Sprintf("HTTP/1.1 %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+					"Sec-WebSocket-Accept: %s\r\n\r\n", status, accept)
+				server.Write(append([]byte(resp), wsFrame(true, wsBinary, nil, "SSH-2.0-server\r\n")...))
+
+				op, payload, err := readClientFrame(br)
+				if err != nil {
+					return
+				}
+				got <- fmt.Sprintf("%#x %q", op, payload)
+			}()
+
+			wc, err := wsHandshake(client, u)
+			if req, want := <-got, "GET relay.example.com /ssh/host websocket "+tt.auth; req != want {
+				t.Errorf("server got %q, want %q", req, want)
+			}
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("wsHandshake: %s", err)
+			case tt.wantErr != "":
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("wsHandshake: %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			banner := make([]byte, len("SSH-2.0-server\r\n"))
+			if _, err := io.ReadFull(wc, banner); err != nil || string(banner) != "SSH-2.0-server\r\n" {
+				t.Errorf("read %q, %v", banner, err)
+			}
+			if _, err := wc.Write([]byte("SSH-2.0-client\r\n")); err != nil {
+				t.Fatal(err)
+			}
+			if frame := <-got; frame != `0x2 "SSH-2.0-client\r\n"` {
+				t.Errorf("server got frame %s", frame)
+			}
+		})
+	}
+}
+
+func TestWSConnRead(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	wc := &wsConn{Conn: client, br: bufio.NewReader(client)}
+
+	long := strings.Repeat("0123456789", 20)
+	var frames bytes.Buffer
+	frames.Write(wsFrame(false, wsBinary, nil, "hel"))
+	frames.Write(wsFrame(true, wsPing, nil, "hb"))
+	frames.Write(wsFrame(true, wsContinuation, nil, "lo "))
+	frames.Write(wsFrame(true, wsBinary, []byte{1, 2, 3, 4}, "masked "))
+	frames.Write(wsFrame(true, wsBinary, nil, long))
+	frames.Write(wsFrame(true, wsClose, nil, "\x03\xe8"))
+	go server.Write(frames.Bytes())
+
+	replies := make(chan []string, 1)
+	go func() {
+		var got []string
+		for {
+			op, payload, err := readClientFrame(server)
+			if err != nil {
+				got = append(got, err.Error())
+				break
+			}
+			got = append(got, fmt.Sprintf("%#x %q", op, payload))
+			if op == wsClose {
+				break
+			}
+		}
+		replies <- got
+	}()
+
+	data, err := io.ReadAll(wc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "hello masked " + long; string(data) != want {
+		t.Errorf("read %q, want %q", data, want)
+	}
+	got := <-replies
+	if want := []string{`0xa "hb"`, `0x8 "\x03\xe8"`}; strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("client replied with %q, want %q", got, want)
+	}
+}
+
+func TestWSConnBadFrames(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   []byte
+		wantErr string
+	}{
+		{"oversized ping", wsFrame(true, wsPing, nil, strings.Repeat("x", 126)), "invalid control frame (opcode 0x9, 126 bytes)"},
+		{"fragmented ping", wsFrame(false, wsPing, nil, "hb"), "invalid control frame (opcode 0x9, 2 bytes)"},
+		{"oversized close", wsFrame(true, wsClose, nil, strings.Repeat("x", 1000)), "invalid control frame (opcode 0x8, 1000 bytes)"},
+		{"reserved opcode", wsFrame(true, 0x3, nil, "x"), "unknown opcode 0x3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			wc := &wsConn{Conn: client, br: bufio.NewReader(client)}
+			go server.Write(tt.frame)
+
+			_, err := wc.Read(make([]byte, 10))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Read: %v, want an error about %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLookupWebSocket(t *testing.T) {
+	fakeMDNS(t, func(q dnsmessage.Question) []dnsmessage.Resource {
+		if q.Name.String() == "_ssh-ws.myhost.local." && q.Type == dnsmessage.TypeTXT {
+			return []dnsmessage.Resource{{
+				Header: rrHeader("_ssh-ws.myhost.local.", dnsmessage.TypeTXT),
+				Body:   &dnsmessage.TXTResource{TXT: []string{"ws://myhost.local/", "ssh"}},
+			}}
+		}
+		return nil
+	})
+	r := &fakeResolver{txt: map[string][]string{
+		"_ssh-ws.host.example.com":  {"v=1", "wss://ws.example.com/ssh"},
+		"_ssh-ws.other.example.com": {"v=1"},
+	}}
+	tests := []struct {
+		resolver Resolver
+		host     string
+		want     string // "" for an error
+	}{
+		{r, "host.example.com", "wss://ws.example.com/ssh"},
+		{r, "other.example.com", ""},
+		{r, "missing.example.com", ""},
+		{MDNSResolver{Next: r}, "host.example.com", "wss://ws.example.com/ssh"},
+		{MDNSResolver{Next: r}, "myhost.local", "ws://myhost.local/ssh"},
+	}
+	for _, tt := range tests {
+		sd := &SRVDialer{Resolver: tt.resolver}
+		got, err := sd.lookupWebSocket(context.Background(), tt.host)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("lookupWebSocket(%q) with %T = %q, want an error", tt.host, tt.resolver, got)
+		case tt.want != "" && (err != nil || got != tt.want):
+			t.Errorf("lookupWebSocket(%q) with %T = %q, %v; want %q", tt.host, tt.resolver, got, err, tt.want)
+		}
+	}
+}
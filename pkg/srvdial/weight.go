@@ -0,0 +1,75 @@
+package srvdial
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"net"
+	"slices"
+)
+
+// shuffleSRV sorts addrs by priority, and within each priority picks the
+// order at random by weight, as described in RFC 2782. It's what the
+// resolver does too, but with rng, so that with the same seed the order is
+// the same each time. It reports whether there was anything to pick.
+func shuffleSRV(addrs []*net.SRV, rng *rand.Rand) bool {
+	// start from the same order whatever the resolver did:
+	slices.SortFunc(addrs, func(a, b *net.SRV) int {
+		return cmp.Or(
+			cmp.Compare(a.Priority, b.Priority),
+			cmp.Compare(normalizeTarget(a.Target), normalizeTarget(b.Target)),
+			cmp.Compare(a.Port, b.Port),
+		)
+	})
+	picked := false
+	for i := 0; i < len(addrs); {
+		j := i + 1
+		for j < len(addrs) && addrs[j].Priority == addrs[i].Priority {
+			j++
+		}
+		if shuffleByWeight(addrs[i:j], rng) {
+			picked = true
+		}
+		i = j
+	}
+	return picked
+}
+
+// shuffleByWeight orders addrs, all of the same priority, by the selection
+// algorithm of RFC 2782: those with no weight are put first, in random
+// order, and each pick draws a number from 0 to the sum of the weights
+// left, taking the first whose running sum reaches it. Weighted targets
+// so come next with a probability proportional to their weight, and
+// those with no weight only have a small chance each time (when 0 is
+// drawn), rather than none, as with the net package. It reports whether
+// the order was left to chance.
+func shuffleByWeight(addrs []*net.SRV, rng *rand.Rand) bool {
+	if len(addrs) < 2 {
+		return false
+	}
+	rng.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+	slices.SortStableFunc(addrs, func(a, b *net.SRV) int {
+		return cmp.Compare(min(a.Weight, 1), min(b.Weight, 1))
+	})
+
+	sum := 0
+	for _, addr := range addrs {
+		sum += int(addr.Weight)
+	}
+	for len(addrs) > 1 {
+		n := rng.IntN(sum + 1)
+		s := 0
+		for i, addr := range addrs {
+			s += int(addr.Weight)
+			if s >= n {
+				// move it to the front, keeping the rest in order, so
+				// those with no weight stay first:
+				copy(addrs[1:i+1], addrs[:i])
+				addrs[0] = addr
+				break
+			}
+		}
+		sum -= int(addrs[0].Weight)
+		addrs = addrs[1:]
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package srvdial
+
+import (
+	"math/rand/v2"
+	"net"
+	"slices"
+	"testing"
+)
+
+func TestShuffleByWeight(t *testing.T) {
+	const rounds = 10000
+	tests := []struct {
+		name    string
+		weights []uint16
+		first   []float64 // the share of rounds each should come first, roughly
+	}{
+		{"weighted", []uint16{10, 30, 60}, []float64{0.1, 0.3, 0.6}},
+		{"unweighted", []uint16{0, 0, 0, 0}, []float64{0.25, 0.25, 0.25, 0.25}},
+		// weight 0 goes first only when the number drawn is 0, 1 in 101:
+		{"with weight 0", []uint16{0, 50, 50}, []float64{0.01, 0.495, 0.495}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rng := rand.New(rand.NewPCG(1, 2))
+			first := make([]int, len(tt.weights))
+			for range rounds {
+				var addrs []*net.SRV
+				for i, w := range tt.weights {
+					addrs = append(addrs, &net.SRV{Port: uint16(i), Weight: w})
+				}
+				orig := slices.Clone(addrs)
+				if !shuffleByWeight(addrs, rng) {
+					t.Fatal("shuffleByWeight reports nothing left to chance")
+				}
+				first[addrs[0].Port]++
+				slices.SortFunc(addrs, func(a, b *net.SRV) int { return int(a.Port) - int(b.Port) })
+				if !slices.Equal(addrs, orig) {
+					t.Fatal("targets lost or repeated")
+				}
+			}
+			for i, n := range first {
+				got := float64(n) / rounds
+				if got < tt.first[i]*0.8-0.005 || got > tt.first[i]*1.2+0.005 {
+					t.Errorf("target of weight %d first %.3f of the time, want about %.3f", tt.weights[i], got, tt.first[i])
+				}
+			}
+		})
+	}
+}
+
+func TestShuffleSRVSeed(t *testing.T) {
+	order := func(seed uint64) []string {
+		addrs := []*net.SRV{
+			{Target: "c.example.com.", Port: 22, Priority: 1, Weight: 1},
+			{Target: "a.example.com.", Port: 22, Priority: 0, Weight: 0},
+			{Target: "b.example.com.", Port: 22, Priority: 0, Weight: 5},
+			{Target: "d.example.com.", Port: 22, Priority: 0, Weight: 5},
+		}
+		shuffleSRV(addrs, rand.New(rand.NewPCG(seed, 0)))
+		var keys []string
+		for _, addr := range addrs {
+			keys = append(keys, TargetKey(addr))
+		}
+		return keys
+	}
+	want := order(42)
+	if got := order(42); !slices.Equal(got, want) {
+		t.Errorf("seed 42 gave %q, then %q", want, got)
+	}
+	if want[3] != "c.example.com:22" {
+		t.Errorf("order %q doesn't end with priority 1", want)
+	}
+}
@@ -0,0 +1,118 @@
+package srvdial
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// zoneOf returns the DNS zone name is in, going by the SOA record r (see
+// LookupRaw) returns, either as the answer (name is the apex) or in the
+// authority section (name is somewhere below it).
+func zoneOf(ctx context.Context, r Resolver, name string) (string, error) {
+	m, err := LookupRaw(ctx, r, name, dnsmessage.TypeSOA)
+	if err != nil {
+		return "", err
+	}
+	if m.RCode != dnsmessage.RCodeSuccess && m.RCode != dnsmessage.RCodeNameError {
+		return "", fmt.Errorf("lookup %s SOA: %s", name, m.RCode)
+	}
+	for _, rr := range append(m.Answers, m.Authorities...) {
+		if rr.Header.Type == dnsmessage.TypeSOA {
+			return normalizeTarget(rr.Header.Name.String()), nil
+		}
+	}
+	return "", fmt.Errorf("lookup %s SOA: no SOA record", name)
+}
+
+// zoneAhead starts looking up the zone of name, for sameZone, while the SRV
+// query is still in flight, so that it doesn't hold up dialing. The
+// returned func waits for it. If the zone can't be found, only name itself
+// (and what's below it) is taken to be in it.
+func (sd *SRVDialer) zoneAhead(ctx context.Context, name string) func() string {
+	if IsLocal(name) {
+		// mDNS has no zones, nor DNSSEC, but stays on the link
+		return func() string { return "local" }
+	}
+	var zone string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lctx, cancel := sd.lookupContext(ctx)
+		defer cancel()
+		var err error
+		if zone, err = zoneOf(lctx, sd.Resolver, name); err != nil {
+			zone = normalizeTarget(name)
+			sd.logf("Couldn't find the zone of %s, assuming only %s itself: %s", name, zone, err)
+		}
+	}()
+	return func() string {
+		<-done
+		return zone
+	}
+}
+
+// inZone reports whether name is zone, or below it. Nothing is below the
+// root or a top-level domain (but for mDNS's local), as anyone can publish
+// there.
+func inZone(name, zone string) bool {
+	name = normalizeTarget(name)
+	if zone == "" {
+		return false
+	}
+	if !strings.Contains(zone, ".") && zone != "local" {
+		return name == zone
+	}
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// sameZone drops the targets in addrs that aren't in the same zone as name,
+// from zone (see zoneAhead), since whoever can publish SRV records there
+// could otherwise send ssh (and its credentials) anywhere. Targets at or
+// below name itself are always in its zone, so if they all are, zone isn't
+// waited for.
+//
+// Targets outside the zone are kept if the SRV answer for cname is DNSSEC
+// validated, as for MX and SRV in other protocols: then at least the zone's
+// owner really did publish them.
+func (sd *SRVDialer) sameZone(ctx context.Context, cname, name string, zone func() string, addrs []*net.SRV) []*net.SRV {
+	if !slices.ContainsFunc(addrs, func(addr *net.SRV) bool { return !inZone(addr.Target, normalizeTarget(name)) }) {
+		return addrs
+	}
+
+	z := zone()
+	var in, out []*net.SRV
+	for _, addr := range addrs {
+		if inZone(addr.Target, z) {
+			in = append(in, addr)
+		} else {
+			out = append(out, addr)
+		}
+	}
+	if len(out) == 0 {
+		return addrs
+	}
+
+	var ok bool
+	var err error
+	if !IsLocal(name) {
+		lctx, cancel := sd.lookupContext(ctx)
+		_, ok, err = lookupValidated(lctx, sd.Resolver, cname, dnsmessage.TypeSRV)
+		cancel()
+	}
+	switch {
+	case err != nil:
+		sd.logf("Couldn't check DNSSEC for %s: %s", cname, err)
+	case ok:
+		sd.logf("Allowing targets outside %s, the SRV answer is DNSSEC validated", z)
+		return addrs
+	}
+	for _, addr := range out {
+		sd.logf("Refusing %s:%d, it's outside %s and not DNSSEC validated (see -cross-zone)", addr.Target, addr.Port, z)
+	}
+	return in
+}